@@ -0,0 +1,187 @@
+package portergosdk
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/macdaih/porter_go_sdk/packets"
+)
+
+// TopicAliasPolicy picks the alias to reassign once every alias allowed by
+// the server's Topic Alias Maximum is in use.
+type TopicAliasPolicy interface {
+	// Touch records that the alias was just used.
+	Touch(alias uint16)
+	// Evict returns the alias to reuse, or false to publish with the full
+	// topic name and no alias.
+	Evict() (uint16, bool)
+	// Reset forgets every alias, it is called on each new connection.
+	Reset()
+}
+
+// WithTopicAliasMaximum advertises how many topic aliases the server may use
+// on PUBLISH packets sent to the client.
+func WithTopicAliasMaximum(max uint16) Option {
+	return func(c *PorterClient) {
+		c.topicAliasMax = max
+	}
+}
+
+// WithTopicAliasPolicy replaces the default LRU policy, a nil policy disables
+// outbound topic aliases.
+func WithTopicAliasPolicy(policy TopicAliasPolicy) Option {
+	return func(c *PorterClient) {
+		c.aliasPolicy = policy
+	}
+}
+
+type lruAliasPolicy struct {
+	order   *list.List
+	entries map[uint16]*list.Element
+}
+
+// NewLRUAliasPolicy evicts the least recently used alias.
+func NewLRUAliasPolicy() TopicAliasPolicy {
+	return &lruAliasPolicy{
+		order:   list.New(),
+		entries: make(map[uint16]*list.Element),
+	}
+}
+
+func (p *lruAliasPolicy) Touch(alias uint16) {
+	if e, ok := p.entries[alias]; ok {
+		p.order.MoveToFront(e)
+		return
+	}
+	p.entries[alias] = p.order.PushFront(alias)
+}
+
+func (p *lruAliasPolicy) Evict() (uint16, bool) {
+	e := p.order.Back()
+	if e == nil {
+		return 0, false
+	}
+	return e.Value.(uint16), true
+}
+
+func (p *lruAliasPolicy) Reset() {
+	p.order.Init()
+	clear(p.entries)
+}
+
+type topicAlias struct {
+	id uint16
+	// known is set when the server already holds the mapping, the topic
+	// name is then sent empty
+	known bool
+}
+
+// aliasTable maps outbound topics to aliases for a single connection.
+type aliasTable struct {
+	mu      sync.Mutex
+	max     uint16
+	byTopic map[string]uint16
+	byAlias map[uint16]string
+	policy  TopicAliasPolicy
+}
+
+func newAliasTable(max uint16, policy TopicAliasPolicy) *aliasTable {
+	if policy != nil {
+		policy.Reset()
+	}
+
+	return &aliasTable{
+		max:     max,
+		byTopic: make(map[string]uint16),
+		byAlias: make(map[uint16]string),
+		policy:  policy,
+	}
+}
+
+// resolve picks the alias to send topic with. The table is left untouched
+// until commit, so a packet that is never written does not leave the server
+// an alias it did not learn.
+func (t *aliasTable) resolve(topic string) topicAlias {
+	if t == nil || t.max == 0 || t.policy == nil || topic == "" {
+		return topicAlias{}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if id, ok := t.byTopic[topic]; ok {
+		return topicAlias{id: id, known: true}
+	}
+
+	if n := len(t.byAlias); n < int(t.max) {
+		return topicAlias{id: uint16(n + 1)}
+	}
+
+	evicted, ok := t.policy.Evict()
+	if !ok || evicted == 0 || evicted > t.max {
+		return topicAlias{}
+	}
+	return topicAlias{id: evicted}
+}
+
+// commit records the alias a PUBLISH was written with.
+func (t *aliasTable) commit(topic string, alias topicAlias) {
+	if alias.id == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !alias.known {
+		if old, ok := t.byAlias[alias.id]; ok {
+			delete(t.byTopic, old)
+		}
+		t.byTopic[topic] = alias.id
+		t.byAlias[alias.id] = topic
+	}
+	t.policy.Touch(alias.id)
+}
+
+// inboundAliases resolves the topic aliases set by the server for a single
+// connection.
+type inboundAliases struct {
+	max    uint16
+	topics map[uint16]string
+}
+
+func newInboundAliases(max uint16) *inboundAliases {
+	return &inboundAliases{
+		max:    max,
+		topics: make(map[uint16]string),
+	}
+}
+
+// resolve returns the topic name of an inbound PUBLISH, recording the alias
+// when the server sends it along with a topic name.
+func (a *inboundAliases) resolve(topic string, props packets.Properties) (string, ReasonCode, error) {
+	if props.TopicAlias == nil {
+		if topic == "" {
+			return "", ReasonProtocolError, fmt.Errorf("publish without topic name nor topic alias")
+		}
+		return topic, ReasonSuccess, nil
+	}
+
+	alias := *props.TopicAlias
+	if alias == 0 || alias > a.max {
+		return "", ReasonTopicAliasInvalid, fmt.Errorf("topic alias %d out of range 1-%d", alias, a.max)
+	}
+
+	if topic != "" {
+		a.topics[alias] = topic
+		return topic, ReasonSuccess, nil
+	}
+
+	known, ok := a.topics[alias]
+	if !ok {
+		return "", ReasonProtocolError, fmt.Errorf("unknown topic alias %d", alias)
+	}
+
+	return known, ReasonSuccess, nil
+}
@@ -0,0 +1,106 @@
+package portergosdk
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	Gob         ContentType = "application/x-gob"
+	Protobuf    ContentType = "application/x-protobuf"
+	OctetStream ContentType = "application/octet-stream"
+)
+
+var ErrContentType = errors.New("unexpected content type")
+
+// Codec encodes the payloads of a typed Topic, its content type being set on
+// published messages and checked on received ones. Topics pass a pointer to
+// their value to both Marshal and Unmarshal.
+type Codec interface {
+	ContentType() ContentType
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() ContentType { return Json }
+
+func (JSONCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type GobCodec struct{}
+
+func (GobCodec) ContentType() ContentType { return Gob }
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buff bytes.Buffer
+	if err := gob.NewEncoder(&buff).Encode(v); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// RawCodec sends []byte values untouched.
+type RawCodec struct{}
+
+func (RawCodec) ContentType() ContentType { return OctetStream }
+
+func (RawCodec) Marshal(v any) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
+		return b, nil
+	case *[]byte:
+		return *b, nil
+	default:
+		return nil, fmt.Errorf("raw codec cannot encode %T", v)
+	}
+}
+
+func (RawCodec) Unmarshal(data []byte, v any) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("raw codec cannot decode into %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+// ProtoMarshaler and ProtoUnmarshaler are implemented by generated protobuf
+// messages, so the SDK does not depend on a protobuf runtime. The Topic type
+// parameter is then the message struct, not a pointer to it.
+type ProtoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type ProtoUnmarshaler interface {
+	Unmarshal(data []byte) error
+}
+
+type ProtoCodec struct{}
+
+func (ProtoCodec) ContentType() ContentType { return Protobuf }
+
+func (ProtoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(ProtoMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec cannot encode %T", v)
+	}
+	return m.Marshal()
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(ProtoUnmarshaler)
+	if !ok {
+		return fmt.Errorf("protobuf codec cannot decode into %T", v)
+	}
+	return m.Unmarshal(data)
+}
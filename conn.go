@@ -0,0 +1,553 @@
+package portergosdk
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/macdaih/porter_go_sdk/packets"
+)
+
+var (
+	ErrConnectionLost = errors.New("connection lost")
+	ErrClosed         = errors.New("client disconnected")
+	ErrClosing        = errors.New("client is disconnecting")
+)
+
+// defaultConnectTimeout bounds the wait for CONNACK when neither the context
+// nor the keep alive gives one.
+const defaultConnectTimeout = 30 * time.Second
+
+type connection struct {
+	conn   *net.TCPConn
+	reader *bufio.Reader
+
+	wmu sync.Mutex
+
+	// keepAlive is the interval negotiated with the server
+	keepAlive time.Duration
+	lastSent  atomic.Int64
+	pingSent  atomic.Int64
+
+	aliases   *aliasTable
+	inAliases *inboundAliases
+
+	// maxPacketSize is the limit announced by the server in CONNACK
+	maxPacketSize uint32
+
+	version ProtocolVersion
+	logger  *slog.Logger
+
+	// responseInfo is the Response Information announced in CONNACK
+	responseInfo string
+
+	// workers handle the inbound messages when a pool is configured
+	workers []*jobQueue
+	// receiving counts the QoS 1 and 2 messages not handled yet, guarded by
+	// pc.mu
+	receiving int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (c *connection) write(b []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	return c.writeLocked(b)
+}
+
+func (c *connection) writeLocked(b []byte) error {
+	if err := checkPacketSize(b, c.maxPacketSize); err != nil {
+		return err
+	}
+
+	_, err := c.conn.Write(b)
+	c.lastSent.Store(time.Now().UnixNano())
+	if err == nil {
+		c.logger.Debug("packet sent", "type", frameType(b), "size", len(b))
+	}
+	return err
+}
+
+// writePublish resolves the topic alias under the write lock so the server
+// learns an alias before any packet relying on it.
+func (c *connection) writePublish(msg AppMessage, pktID uint16, dup bool) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	alias := c.aliases.resolve(msg.TopicName)
+	enc, err := buildPublish(c.version, msg, pktID, dup, alias)
+	if err != nil {
+		return err
+	}
+
+	if err := c.writeLocked(enc); err != nil {
+		return err
+	}
+
+	c.aliases.commit(msg.TopicName, alias)
+	return nil
+}
+
+func (c *connection) close() error {
+	c.cancel()
+	return c.conn.Close()
+}
+
+func connectTimeout(keepAlive uint16) time.Duration {
+	if keepAlive == 0 {
+		return defaultConnectTimeout
+	}
+	return time.Duration(keepAlive) * time.Second
+}
+
+// lockDial takes pc.dialMu, giving up once ctx is done.
+func (pc *PorterClient) lockDial(ctx context.Context) error {
+	select {
+	case pc.dialMu <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (pc *PorterClient) unlockDial() {
+	<-pc.dialMu
+}
+
+// ensureConnected dials the server unless a connection is already up.
+func (pc *PorterClient) ensureConnected(ctx context.Context) error {
+	if err := pc.lockDial(ctx); err != nil {
+		return err
+	}
+	defer pc.unlockDial()
+
+	pc.mu.Lock()
+	closing := pc.closing
+	connected := pc.cur != nil
+	stopped := pc.done == nil || isClosed(pc.done)
+	pc.mu.Unlock()
+
+	switch {
+	case closing:
+		return ErrClosing
+	case connected:
+		return nil
+	}
+
+	if stopped {
+		// the store is read before taking pc.mu, pc.dialMu keeping another
+		// dial from starting meanwhile
+		pkts, err := pc.loadSession()
+		if err != nil {
+			return err
+		}
+
+		pc.mu.Lock()
+		pc.restoreSession(pkts)
+		pc.done = make(chan struct{})
+		pc.doneErr = nil
+		pc.redirects = 0
+		pc.mu.Unlock()
+	}
+
+	if err := pc.dial(ctx); err != nil {
+		return err
+	}
+
+	return pc.resume()
+}
+
+// resume restores the subscriptions and sends the in-flight exchanges again
+// on a connection just dialed, then drains the offline queue. It runs once
+// per connection, with pc.dialMu held, as exchanges are only resent when
+// reconnecting.
+func (pc *PorterClient) resume() error {
+	pc.mu.Lock()
+	topics := make([]string, 0, len(pc.subscribed))
+	for topic := range pc.subscribed {
+		topics = append(topics, topic)
+	}
+	pc.mu.Unlock()
+
+	if len(topics) > 0 {
+		if _, err := pc.sendSubscribe(topics); err != nil {
+			return err
+		}
+	}
+
+	// exchanges restored from the session store or left by a failed
+	// connection are sent again
+	if err := pc.resendInflight(); err != nil {
+		return err
+	}
+
+	pc.drainQueue()
+	return nil
+}
+
+func (pc *PorterClient) dial(ctx context.Context) error {
+	pc.mu.Lock()
+	version := pc.version
+	host := pc.serverHost
+	pc.mu.Unlock()
+
+	logger := pc.logger.With("server", host)
+	logger.Debug("dialing", "version", int(version))
+
+	var dialer net.Dialer
+	nc, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		logger.Warn("dial failed", "error", err)
+		return err
+	}
+
+	connCtx, cancel := context.WithCancel(context.Background())
+	c := &connection{
+		conn:    nc.(*net.TCPConn),
+		reader:  bufio.NewReader(nc),
+		version: version,
+		logger:  logger,
+		ctx:     connCtx,
+		cancel:  cancel,
+	}
+
+	if err := pc.connect(ctx, c); err != nil {
+		c.close()
+		// the server closes the connection after refusing the version
+		if pc.fallbackVersion(err) {
+			logger.Warn("protocol version refused, falling back", "version", int(version), "error", err)
+			return pc.dial(ctx)
+		}
+		logger.Error("connect failed", "error", err)
+		return err
+	}
+
+	pc.mu.Lock()
+	pc.cur = c
+	pc.mu.Unlock()
+
+	pc.startWorkers(c)
+	go pc.readLoop(c)
+	go pc.keepAliveLoop(c)
+
+	return nil
+}
+
+// connect runs the CONNECT / CONNACK exchange on a freshly dialed connection.
+// connect sends CONNECT and reads CONNACK within the deadline of ctx, or
+// within the keep alive when ctx has none so a silent server does not hold
+// pc.dialMu forever.
+func (pc *PorterClient) connect(ctx context.Context, c *connection) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(connectTimeout(pc.keepAlive))
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	defer c.conn.SetDeadline(time.Time{})
+
+	creds, err := pc.credentials(ctx)
+	if err != nil {
+		return err
+	}
+
+	msg, err := buildConnect(
+		c.version,
+		pc.clientID,
+		pc.keepAlive,
+		creds,
+		pc.will,
+		pc.sessionExpiry,
+		pc.topicAliasMax,
+		pc.receivedMax,
+		pc.maxPacketSize,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := c.write(msg); err != nil {
+		return err
+	}
+
+	// a server that only speaks MQTT 3.1.1 answers with a 3.1.1 CONNACK, the
+	// only one with a remaining length of 2
+	version := c.version
+	if b, err := c.reader.Peek(2); err == nil && b[0] == connackcmd && b[1] == 2 {
+		version = V311
+	}
+
+	pkt, err := packets.Codec{Version: version, MaxPacketSize: pc.maxPacketSize}.ReadPacket(c.reader)
+	if err != nil {
+		return err
+	}
+
+	res, err := readConnack(pkt, version)
+	if err != nil {
+		return err
+	}
+
+	if res.code.IsError() {
+		return &ReasonError{
+			Packet:         CodeConnack,
+			Code:           res.code,
+			Reason:         res.reason,
+			UserProperties: res.userProps,
+		}
+	}
+
+	discard := func() {}
+
+	pc.mu.Lock()
+	if !res.sessionPresent {
+		discard = pc.discardInbound()
+	}
+	pc.quota.reset(int(res.receiveMax), len(pc.outbound))
+	pc.serverMaxPacketSize = res.maxPacketSize
+	// the identifier the server assigned resumes the session next time
+	if res.assignedID != "" {
+		pc.clientID = res.assignedID
+	}
+	pc.mu.Unlock()
+
+	discard()
+
+	c.maxPacketSize = res.maxPacketSize
+	c.responseInfo = res.responseInfo
+	c.aliases = newAliasTable(res.topicAliasMax, pc.aliasPolicy)
+	c.inAliases = newInboundAliases(pc.topicAliasMax)
+
+	c.keepAlive = time.Duration(pc.keepAlive) * time.Second
+	if res.serverKeepAlive != nil {
+		c.keepAlive = time.Duration(*res.serverKeepAlive) * time.Second
+	}
+
+	c.logger.Info("connected",
+		"version", int(c.version),
+		"session_present", res.sessionPresent,
+		"keep_alive", c.keepAlive,
+		"receive_maximum", res.receiveMax,
+		"maximum_packet_size", res.maxPacketSize,
+	)
+
+	return nil
+}
+
+func (pc *PorterClient) readLoop(c *connection) {
+	for {
+		pkt, err := packets.Codec{Version: c.version, MaxPacketSize: pc.maxPacketSize}.ReadPacket(c.reader)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrPacketTooLarge):
+				err = c.disconnectWithError(ReasonPacketTooLarge, err.Error())
+			case !decodeError(err):
+				err = fmt.Errorf("%w : %w", ErrConnectionLost, err)
+			}
+			pc.connectionLost(c, err)
+			return
+		}
+		c.logger.Debug("packet received", "type", pkt.Type().String())
+
+		if _, ok := pkt.(*packets.Pingresp); ok {
+			c.pingSent.Store(0)
+			continue
+		}
+
+		if err := pc.readMessage(c, pkt); err != nil {
+			pc.connectionLost(c, err)
+			return
+		}
+	}
+}
+
+// decodeError reports whether err comes from a packet the server sent that
+// could not be decoded, rather than from reading the connection.
+func decodeError(err error) bool {
+	return errors.Is(err, packets.ErrMalformedPacket) ||
+		errors.Is(err, packets.ErrProtocolError) ||
+		errors.Is(err, packets.ErrInvalidType) ||
+		errors.Is(err, packets.ErrVersion)
+}
+
+func (pc *PorterClient) current() (*connection, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.cur == nil {
+		return nil, ErrConnectionLost
+	}
+	return pc.cur, nil
+}
+
+func (pc *PorterClient) writePublish(msg AppMessage, pktID uint16, dup bool) error {
+	c, err := pc.current()
+	if err != nil {
+		return err
+	}
+
+	return pc.writePublishOn(c, msg, pktID, dup)
+}
+
+func (pc *PorterClient) writePublishOn(c *connection, msg AppMessage, pktID uint16, dup bool) error {
+	if err := c.writePublish(msg, pktID, dup); err != nil {
+		return err
+	}
+
+	c.logger.Debug("publish sent",
+		"topic", msg.TopicName,
+		"qos", int(msg.MessageQoS.level()),
+		"packet_id", pktID,
+		"dup", dup,
+		pc.payloadAttr(msg.Payload),
+	)
+	return nil
+}
+
+func (pc *PorterClient) connectionLost(c *connection, err error) {
+	pc.mu.Lock()
+	if pc.cur != c {
+		// the connection was closed on purpose
+		pc.mu.Unlock()
+		return
+	}
+	pc.cur = nil
+	closing := pc.closing
+	pc.mu.Unlock()
+
+	c.close()
+
+	if closing {
+		pc.stop(err)
+		return
+	}
+
+	c.logger.Warn("connection lost", "error", err)
+
+	if ref, ok := redirect(err); ok && pc.followRedirect(ref) {
+		if rerr := pc.reconnect(); rerr == nil {
+			return
+		}
+	}
+
+	if errors.Is(err, ErrConnectionLost) && pc.reconnectAttempts > 0 {
+		if rerr := pc.reconnectWithBackoff(); rerr == nil {
+			return
+		}
+	}
+
+	pc.stop(err)
+}
+
+// followRedirect points the client to the server a DISCONNECT referred to,
+// reporting false once the redirects allowed are used up.
+func (pc *PorterClient) followRedirect(ref string) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.redirects >= pc.maxRedirects {
+		return false
+	}
+
+	host := serverReference(ref, pc.serverHost)
+	if host == "" {
+		return false
+	}
+
+	pc.redirects++
+	pc.logger.Info("following server redirect", "from", pc.serverHost, "to", host)
+	pc.serverHost = host
+	return true
+}
+
+// reconnect dials again and restores the subscriptions and the in-flight
+// exchanges of the previous connection, unless a connection was dialed and
+// resumed meanwhile or the client was closed.
+func (pc *PorterClient) reconnect() error {
+	ctx, cancel := withTimedContext(context.Background(), pc.sessionDuration)
+	defer cancel()
+
+	if err := pc.lockDial(ctx); err != nil {
+		return err
+	}
+	defer pc.unlockDial()
+
+	pc.mu.Lock()
+	closing := pc.closing
+	stopped := pc.done == nil || isClosed(pc.done)
+	connected := pc.cur != nil
+	pc.mu.Unlock()
+
+	// checked under pc.dialMu so a Disconnect that already returned is not
+	// followed by a new connection
+	switch {
+	case closing:
+		return ErrClosing
+	case stopped:
+		return ErrClosed
+	case connected:
+		return nil
+	}
+
+	if err := pc.dial(ctx); err != nil {
+		return err
+	}
+
+	return pc.resume()
+}
+
+func (pc *PorterClient) stop(err error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.done == nil || isClosed(pc.done) {
+		return
+	}
+
+	pc.doneErr = err
+	close(pc.done)
+
+	if err != nil {
+		pc.logger.Error("client stopped", "error", err)
+	} else {
+		pc.logger.Info("client stopped")
+	}
+
+	if err == nil {
+		err = ErrClosed
+	}
+	pc.failInflight(err)
+	pc.closeChannels()
+}
+
+func (pc *PorterClient) stopped() <-chan struct{} {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	return pc.done
+}
+
+func (pc *PorterClient) stopErr() error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	return pc.doneErr
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,126 @@
+package portergosdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrNoCredentials = errors.New("no credentials provided")
+
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// CredentialsProvider is called before every CONNECT, including reconnects,
+// so implementations can hand out rotated secrets.
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+type CredentialsProviderFunc func(ctx context.Context) (Credentials, error)
+
+func (fn CredentialsProviderFunc) Credentials(ctx context.Context) (Credentials, error) {
+	return fn(ctx)
+}
+
+func WithCredentialsProvider(provider CredentialsProvider, timeout time.Duration) Option {
+	return func(c *PorterClient) {
+		c.credsProvider = provider
+		c.credsTimeout = timeout
+	}
+}
+
+func (pc *PorterClient) credentials(ctx context.Context) (*credential, error) {
+	if pc.credsProvider == nil {
+		return pc.creds, nil
+	}
+
+	ctx, cancel := withTimedContext(ctx, pc.credsTimeout)
+	defer cancel()
+
+	type result struct {
+		creds Credentials
+		err   error
+	}
+
+	res := make(chan result, 1)
+	go func() {
+		creds, err := pc.credsProvider.Credentials(ctx)
+		res <- result{creds: creds, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to retrieve credentials : %w", ctx.Err())
+	case r := <-res:
+		if r.err != nil {
+			return nil, fmt.Errorf("failed to retrieve credentials : %w", r.err)
+		}
+
+		return &credential{
+			usr: &r.creds.Username,
+			pwd: &r.creds.Password,
+		}, nil
+	}
+}
+
+// FileCredentials reads JSON encoded credentials from disk and reloads them
+// whenever the file modification time or size changes.
+type FileCredentials struct {
+	path string
+
+	mu      sync.Mutex
+	loaded  bool
+	modTime time.Time
+	size    int64
+	creds   Credentials
+}
+
+func NewFileCredentials(path string) *FileCredentials {
+	return &FileCredentials{path: path}
+}
+
+func (fc *FileCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	if err := ctx.Err(); err != nil {
+		return Credentials{}, err
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	info, err := os.Stat(fc.path)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	if fc.loaded && info.ModTime().Equal(fc.modTime) && info.Size() == fc.size {
+		return fc.creds, nil
+	}
+
+	raw, err := os.ReadFile(fc.path)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	var creds Credentials
+	if err := json.Unmarshal(raw, &creds); err != nil {
+		return Credentials{}, fmt.Errorf("failed to parse credentials file %s : %w", fc.path, err)
+	}
+
+	if creds.Username == "" && creds.Password == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+
+	fc.creds = creds
+	fc.modTime = info.ModTime()
+	fc.size = info.Size()
+	fc.loaded = true
+
+	return creds, nil
+}
@@ -0,0 +1,231 @@
+package portergosdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/macdaih/porter_go_sdk/packets"
+)
+
+// Disconnect holds the content of a DISCONNECT packet sent by the server.
+type Disconnect struct {
+	ReasonCode      ReasonCode
+	Reason          string
+	SessionExpiry   uint32
+	ServerReference string
+	UserProperties  []UserProperty
+}
+
+// Redirect reports whether the server asked the client to use another server.
+func (d Disconnect) Redirect() bool {
+	return (d.ReasonCode == ReasonUseAnotherServer || d.ReasonCode == ReasonServerMoved) &&
+		d.ServerReference != ""
+}
+
+type DisconnectError struct {
+	Disconnect
+}
+
+func (e *DisconnectError) Error() string {
+	msg := fmt.Sprintf(
+		"disconnected by server with reason code 0x%02X (%s)",
+		byte(e.ReasonCode),
+		e.ReasonCode,
+	)
+	if e.Reason != "" {
+		msg += " : " + e.Reason
+	}
+	return msg
+}
+
+func (e *DisconnectError) Unwrap() error {
+	return &ReasonError{
+		Packet:         CodeDisconnect,
+		Code:           e.ReasonCode,
+		Reason:         e.Reason,
+		UserProperties: e.UserProperties,
+	}
+}
+
+func WithDisconnectCallBack(fn func(ctx context.Context, d Disconnect)) Option {
+	return func(c *PorterClient) {
+		c.disconnectHandler = fn
+	}
+}
+
+// WithFollowRedirects makes the client reconnect to the server reference
+// given along a Use Another Server or Server Moved reason code, at most
+// maxHops times per call.
+func WithFollowRedirects(maxHops int) Option {
+	return func(c *PorterClient) {
+		c.maxRedirects = maxHops
+	}
+}
+
+func readDisconnect(pkt *packets.Disconnect) (Disconnect, error) {
+	d := Disconnect{
+		ReasonCode:      ReasonCode(pkt.ReasonCode),
+		Reason:          pkt.Properties.ReasonString,
+		SessionExpiry:   deref(pkt.Properties.SessionExpiryInterval),
+		ServerReference: pkt.Properties.ServerReference,
+		UserProperties:  pkt.Properties.UserProperties,
+	}
+
+	if !d.ReasonCode.ValidFor(CodeDisconnect) {
+		return d, fmt.Errorf("%w : invalid disconnect reason code 0x%02X", ErrMalformedPacket, pkt.ReasonCode)
+	}
+
+	return d, nil
+}
+
+// serverReference picks the first entry of a server reference and completes
+// it with the port of the current host when none is provided.
+func serverReference(ref string, current string) string {
+	fields := strings.Fields(ref)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	host := fields[0]
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+
+	_, port, err := net.SplitHostPort(current)
+	if err != nil {
+		return host
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// redirect returns the server to reconnect to when err carries a redirection.
+func redirect(err error) (string, bool) {
+	if err == nil {
+		return "", false
+	}
+
+	var de *DisconnectError
+	if errors.As(err, &de) && de.Redirect() {
+		return de.ServerReference, true
+	}
+
+	return "", false
+}
+
+type DisconnectOptions struct {
+	// ReasonCode is ReasonNormalDisconnection by default, use
+	// ReasonDisconnectWithWillMessage to have the server publish the will.
+	ReasonCode ReasonCode
+	// SessionExpiry overrides the session expiry interval sent in CONNECT.
+	SessionExpiry  *uint32
+	Reason         string
+	UserProperties []UserProperty
+}
+
+// Disconnect waits for the in-flight QoS exchanges to complete, up to the
+// context deadline, then sends a DISCONNECT packet and closes the connection.
+// With MQTT 3.1.1 the DISCONNECT carries none of the options.
+func (pc *PorterClient) Disconnect(ctx context.Context, opts DisconnectOptions) error {
+	if !opts.ReasonCode.ValidFor(CodeDisconnect) {
+		return fmt.Errorf("invalid disconnect reason code 0x%02X", byte(opts.ReasonCode))
+	}
+
+	// A session expiry cannot be set on disconnect when CONNECT had none
+	if opts.SessionExpiry != nil && *opts.SessionExpiry > 0 && pc.sessionExpiry == 0 {
+		return fmt.Errorf("session expiry cannot be set on disconnect when connected without one")
+	}
+
+	if _, err := buildDisconnect(V5, opts); err != nil {
+		return err
+	}
+
+	pc.mu.Lock()
+	if pc.closing {
+		pc.mu.Unlock()
+		return ErrClosing
+	}
+	pc.closing = true
+	pc.mu.Unlock()
+
+	defer func() {
+		pc.mu.Lock()
+		pc.closing = false
+		pc.mu.Unlock()
+	}()
+
+drain:
+	for pc.inflightCount() > 0 {
+		select {
+		case <-ctx.Done():
+			break drain
+		case <-pc.drained:
+		}
+	}
+
+	pc.mu.Lock()
+	c := pc.cur
+	pc.cur = nil
+	pc.mu.Unlock()
+
+	if c == nil {
+		pc.stop(nil)
+		return nil
+	}
+
+	// MQTT 3.1.1 has no reason code to keep the will, the connection is
+	// closed without DISCONNECT so the server publishes it
+	if c.version < V5 && opts.ReasonCode == ReasonDisconnectWithWillMessage {
+		err := c.close()
+		pc.stop(nil)
+		return err
+	}
+
+	enc, err := fitDisconnect(c.version, opts, c.maxPacketSize)
+	if err != nil {
+		c.close()
+		pc.stop(nil)
+		return err
+	}
+
+	werr := c.write(enc)
+	if err := c.close(); err != nil && werr == nil {
+		werr = err
+	}
+
+	pc.stop(nil)
+	return werr
+}
+
+// disconnectWithError notifies the server of a protocol violation before the
+// read loop tears the connection down.
+func (c *connection) disconnectWithError(code ReasonCode, reason string) error {
+	// an MQTT 3.1.1 DISCONNECT would discard the will, the connection is
+	// only closed
+	if c.version == V5 {
+		if enc, err := buildDisconnect(c.version, DisconnectOptions{ReasonCode: code, Reason: reason}); err == nil {
+			_ = c.write(enc)
+		}
+	}
+
+	return &ReasonError{Packet: CodeDisconnect, Code: code, Reason: reason}
+}
+
+func buildDisconnect(version ProtocolVersion, opts DisconnectOptions) ([]byte, error) {
+	// MQTT 3.1.1 DISCONNECT is always a bare normal disconnection
+	if version < V5 {
+		return packets.Codec{Version: version}.Marshal(&packets.Disconnect{})
+	}
+
+	return packets.Marshal(&packets.Disconnect{
+		ReasonCode: byte(opts.ReasonCode),
+		Properties: packets.Properties{
+			SessionExpiryInterval: opts.SessionExpiry,
+			ReasonString:          opts.Reason,
+			UserProperties:        opts.UserProperties,
+		},
+	})
+}
@@ -29,4 +29,8 @@ func main() {
 		panic(err)
 	}
 
+	if err := client.Disconnect(context.Background(), sdk.DisconnectOptions{}); err != nil {
+		panic(err)
+	}
+
 }
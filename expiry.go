@@ -0,0 +1,55 @@
+package portergosdk
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrMessageExpired = errors.New("message expired")
+
+// remainingExpiry returns msg with the expiry interval left since it was
+// created, false once it expired.
+func remainingExpiry(msg AppMessage, created time.Time, now time.Time) (AppMessage, bool) {
+	if msg.Expiry == 0 {
+		return msg, true
+	}
+
+	elapsed := uint32(now.Sub(created) / time.Second)
+	if elapsed >= msg.Expiry {
+		return msg, false
+	}
+
+	msg.Expiry -= elapsed
+	return msg, true
+}
+
+// acquireQuota waits for room in the send quota no longer than the message
+// published at created lives.
+func (pc *PorterClient) acquireQuota(ctx context.Context, msg AppMessage, created time.Time) error {
+	qctx := ctx
+	if msg.Expiry > 0 {
+		var cancel context.CancelFunc
+		qctx, cancel = context.WithDeadline(ctx, created.Add(time.Duration(msg.Expiry)*time.Second))
+		defer cancel()
+	}
+
+	if err := pc.quota.acquire(qctx); err != nil {
+		if ctx.Err() == nil {
+			return ErrMessageExpired
+		}
+		return err
+	}
+	return nil
+}
+
+// messageContext bounds the handling of an inbound message to its expiry,
+// counted from when it was read, and marks it as a handler context.
+func messageContext(ctx context.Context, msg AppMessage, received time.Time) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, handlerKey{}, true)
+	if msg.Expiry == 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithDeadline(ctx, received.Add(time.Duration(msg.Expiry)*time.Second))
+}
@@ -0,0 +1,112 @@
+package portergosdk
+
+import (
+	"context"
+	"sync"
+)
+
+const defaultReceiveMaximum = 65535
+
+// sendQuota bounds the number of outbound QoS 1 and 2 exchanges to the
+// Receive Maximum announced by the server.
+type sendQuota struct {
+	mu    sync.Mutex
+	max   int
+	used  int
+	freed chan struct{}
+}
+
+func newSendQuota() *sendQuota {
+	return &sendQuota{
+		max:   defaultReceiveMaximum,
+		freed: make(chan struct{}),
+	}
+}
+
+// acquire blocks until the window has room or the context ends.
+func (q *sendQuota) acquire(ctx context.Context) error {
+	for {
+		q.mu.Lock()
+		if q.used < q.max {
+			q.used++
+			q.mu.Unlock()
+			return nil
+		}
+		freed := q.freed
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+func (q *sendQuota) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.used > 0 {
+		q.used--
+	}
+	q.wake()
+}
+
+// reset applies the Receive Maximum of a new connection, used being the
+// number of exchanges carried over from the previous one.
+func (q *sendQuota) reset(max int, used int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if max <= 0 {
+		max = defaultReceiveMaximum
+	}
+	q.max = max
+	q.used = used
+	q.wake()
+}
+
+// wake unblocks every waiter, q.mu must be held.
+func (q *sendQuota) wake() {
+	close(q.freed)
+	q.freed = make(chan struct{})
+}
+
+// WithMaxMessage sets the Receive Maximum advertised in CONNECT, the number
+// of QoS 1 and 2 messages the server may have in flight towards the client.
+func WithMaxMessage(max int) Option {
+	return func(c *PorterClient) {
+		c.receivedMax = max
+	}
+}
+
+// receiveExceeded reports whether the server has more QoS 1 and 2 exchanges
+// in flight than the Receive Maximum allows, pc.mu must be held. MQTT 3.1.1
+// has no Receive Maximum to enforce.
+func (pc *PorterClient) receiveExceeded(c *connection) bool {
+	return pc.version == V5 &&
+		pc.receivedMax > 0 &&
+		c.receiving+len(pc.inbound) > pc.receivedMax
+}
+
+// receiving counts a QoS 1 or 2 message until it is handled and reports
+// whether the Receive Maximum still holds. The count belongs to the
+// connection, so the messages dropped along with it are not counted against
+// the next one.
+func (pc *PorterClient) receiving(c *connection) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	c.receiving++
+	return !pc.receiveExceeded(c)
+}
+
+func (pc *PorterClient) handled(c *connection) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if c.receiving > 0 {
+		c.receiving--
+	}
+}
@@ -0,0 +1,393 @@
+package portergosdk
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/macdaih/porter_go_sdk/packets"
+)
+
+var ErrNoPacketID = errors.New("no packet identifier available")
+
+// inflight tracks an outbound QoS 1 or 2 PUBLISH until its exchange completes.
+type inflight struct {
+	id  uint16
+	msg AppMessage
+	// created is when the message was published, its expiry counting from
+	// then
+	created time.Time
+	// seq orders the exchanges as they were sent, for resending them in the
+	// same order
+	seq uint64
+
+	// released is set once PUBREC was received and PUBREL sent
+	released bool
+
+	*token
+}
+
+type pendingSub struct {
+	topics []string
+	done   chan struct{}
+	err    error
+}
+
+// packetID returns the next free packet identifier, pc.mu must be held.
+func (pc *PorterClient) packetID() (uint16, error) {
+	for i := 0; i < 0xFFFF; i++ {
+		pc.nextPacketID++
+		if pc.nextPacketID == 0 {
+			pc.nextPacketID = 1
+		}
+
+		id := pc.nextPacketID
+		if _, ok := pc.outbound[id]; ok {
+			continue
+		}
+		if _, ok := pc.pendingSubs[id]; ok {
+			continue
+		}
+		return id, nil
+	}
+
+	return 0, ErrNoPacketID
+}
+
+// newInflight starts tracking msg, published at created, unless the client
+// stopped while it waited for room in the send quota.
+func (pc *PorterClient) newInflight(msg AppMessage, created time.Time) (*inflight, error) {
+	pc.mu.Lock()
+
+	if pc.done == nil || isClosed(pc.done) {
+		err := pc.doneErr
+		pc.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrClosed
+	}
+
+	id, err := pc.packetID()
+	if err != nil {
+		pc.mu.Unlock()
+		return nil, err
+	}
+
+	pc.sendSeq++
+	inf := &inflight{
+		id:      id,
+		msg:     msg,
+		created: created,
+		seq:     pc.sendSeq,
+		token:   newToken(),
+	}
+	pc.outbound[id] = inf
+	persist := pc.persist(StoredPacket{Direction: Outbound, PacketID: id, Created: created, Seq: inf.seq, Message: msg})
+	pc.mu.Unlock()
+
+	// the packet identifier stays reserved while the exchange is persisted
+	if err := persist(); err != nil {
+		pc.mu.Lock()
+		if pc.outbound[id] == inf {
+			delete(pc.outbound, id)
+		}
+		pc.mu.Unlock()
+		return nil, err
+	}
+
+	return inf, nil
+}
+
+func (pc *PorterClient) handleAck(cmd packetType, id uint16, code ReasonCode, props packets.Properties) error {
+	if code.IsError() {
+		pc.logger.Warn("acknowledgement refused", "type", packets.Type(byte(cmd)>>4).String(), "packet_id", id, "reason_code", int(code))
+	} else {
+		pc.logger.Debug("acknowledgement received", "type", packets.Type(byte(cmd)>>4).String(), "packet_id", id, "reason_code", int(code))
+	}
+
+	switch cmd {
+	case pubrelcmd:
+		pc.mu.Lock()
+		_, ok := pc.inbound[id]
+		delete(pc.inbound, id)
+		forget := pc.forget(Inbound, id)
+		pc.mu.Unlock()
+
+		forget()
+		pc.signalDrained()
+
+		if !ok {
+			return pc.sendAck(pubcompcmd, id, ReasonPacketIdentifierNotFound)
+		}
+		return pc.sendAck(pubcompcmd, id, ReasonSuccess)
+	case pubreccmd:
+		forget := func() {}
+		persist := func() error { return nil }
+
+		pc.mu.Lock()
+		inf, ok := pc.outbound[id]
+		if ok && code.IsError() {
+			delete(pc.outbound, id)
+			forget = pc.forget(Outbound, id)
+		}
+		if ok && !code.IsError() && !inf.released {
+			inf.released = true
+			persist = pc.persist(StoredPacket{Direction: Outbound, PacketID: id, Released: true, Created: inf.created, Seq: inf.seq, Message: inf.msg})
+		}
+		pc.mu.Unlock()
+
+		forget()
+		if err := persist(); err != nil {
+			return err
+		}
+
+		if !ok {
+			return pc.sendAck(pubrelcmd, id, ReasonPacketIdentifierNotFound)
+		}
+
+		if code.IsError() {
+			pc.finish(inf, code, newReasonError(CodePubRec, code, props))
+			return nil
+		}
+		return pc.sendAck(pubrelcmd, id, ReasonSuccess)
+	default:
+		packet := CodePubAck
+		if cmd == pubcompcmd {
+			packet = CodePubComp
+		}
+
+		pc.mu.Lock()
+		inf, ok := pc.outbound[id]
+		delete(pc.outbound, id)
+		forget := pc.forget(Outbound, id)
+		pc.mu.Unlock()
+
+		forget()
+		if !ok {
+			return nil
+		}
+
+		var err error
+		if code.IsError() {
+			err = newReasonError(packet, code, props)
+		}
+		pc.finish(inf, code, err)
+		return nil
+	}
+}
+
+// duplicateQoS2 reports whether an inbound QoS 2 message was already
+// delivered, duplicates being dropped until PUBREL.
+func (pc *PorterClient) duplicateQoS2(id uint16) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	_, ok := pc.inbound[id]
+	return ok
+}
+
+// deliveredQoS2 records the packet identifier of a delivered QoS 2 message.
+// It is recorded once the message was handled and persisted before PUBREC so
+// a restart does not deliver it twice.
+func (pc *PorterClient) deliveredQoS2(id uint16) error {
+	pc.mu.Lock()
+	persist := pc.persist(StoredPacket{Direction: Inbound, PacketID: id})
+	pc.mu.Unlock()
+
+	if err := persist(); err != nil {
+		return err
+	}
+
+	pc.mu.Lock()
+	pc.inbound[id] = struct{}{}
+	pc.mu.Unlock()
+	return nil
+}
+
+// finish completes an outbound exchange and frees its slot in the send quota.
+func (pc *PorterClient) finish(inf *inflight, code ReasonCode, err error) {
+	inf.complete(code, err)
+	pc.quota.release()
+	pc.signalDrained()
+}
+
+func (pc *PorterClient) sendAck(cmd packetType, id uint16, code ReasonCode) error {
+	c, err := pc.current()
+	if err != nil {
+		return err
+	}
+
+	return c.sendAck(cmd, id, code)
+}
+
+func (c *connection) sendAck(cmd packetType, id uint16, code ReasonCode) error {
+	enc, err := buildAck(c.version, cmd, id, code)
+	if err != nil {
+		return err
+	}
+	return c.write(enc)
+}
+
+func (pc *PorterClient) resendInflight() error {
+	pc.mu.Lock()
+	pending := make([]*inflight, 0, len(pc.outbound))
+	for _, inf := range pc.outbound {
+		pending = append(pending, inf)
+	}
+	pc.mu.Unlock()
+
+	// unacknowledged packets are sent again in their original order
+	slices.SortFunc(pending, func(a, b *inflight) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	now := time.Now()
+	for _, inf := range pending {
+		if inf.released {
+			if err := pc.sendAck(pubrelcmd, inf.id, ReasonSuccess); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// an expired message is not sent again, the others carry the
+		// interval left
+		msg, ok := remainingExpiry(inf.msg, inf.created, now)
+		if !ok {
+			pc.logger.Warn("dropping expired message", "topic", inf.msg.TopicName, "packet_id", inf.id)
+			pc.dropInflight(inf, ErrMessageExpired)
+			continue
+		}
+
+		if err := pc.writePublish(msg, inf.id, true); err != nil {
+			if errors.Is(err, ErrPacketTooLarge) {
+				pc.dropInflight(inf, err)
+				continue
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropInflight abandons an exchange whose PUBLISH was never written. Its
+// token is left alone when an acknowledgement or the client stopping already
+// completed it.
+func (pc *PorterClient) dropInflight(inf *inflight, err error) {
+	forget := func() {}
+
+	pc.mu.Lock()
+	tracked := pc.outbound[inf.id] == inf
+	if tracked {
+		delete(pc.outbound, inf.id)
+		forget = pc.forget(Outbound, inf.id)
+	}
+	pc.mu.Unlock()
+
+	forget()
+	if tracked {
+		pc.finish(inf, ReasonUnspecifiedError, err)
+	}
+}
+
+// failInflight ends every pending exchange with err, pc.mu must be held. The
+// session store is left untouched so the exchanges are replayed by the next
+// connection.
+func (pc *PorterClient) failInflight(err error) {
+	for id, inf := range pc.outbound {
+		inf.complete(ReasonUnspecifiedError, err)
+		delete(pc.outbound, id)
+	}
+
+	for id, sub := range pc.pendingSubs {
+		sub.err = err
+		close(sub.done)
+		delete(pc.pendingSubs, id)
+	}
+
+	clear(pc.inbound)
+	pc.quota.reset(0, 0)
+}
+
+func (pc *PorterClient) inflightCount() int {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	return len(pc.outbound) + len(pc.inbound)
+}
+
+func (pc *PorterClient) signalDrained() {
+	select {
+	case pc.drained <- struct{}{}:
+	default:
+	}
+}
+
+func (pc *PorterClient) sendSubscribe(topics []string) (*pendingSub, error) {
+	pc.mu.Lock()
+	id, err := pc.packetID()
+	if err != nil {
+		pc.mu.Unlock()
+		return nil, err
+	}
+
+	sub := &pendingSub{topics: topics, done: make(chan struct{})}
+	pc.pendingSubs[id] = sub
+	pc.mu.Unlock()
+
+	if err := pc.writeSubscribe(topics, id); err != nil {
+		pc.mu.Lock()
+		delete(pc.pendingSubs, id)
+		pc.mu.Unlock()
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+func (pc *PorterClient) writeSubscribe(topics []string, id uint16) error {
+	c, err := pc.current()
+	if err != nil {
+		return err
+	}
+
+	msg, err := buildSubscribe(c.version, topics, id, pc.qos.level())
+	if err != nil {
+		return err
+	}
+	return c.write(msg)
+}
+
+func (pc *PorterClient) completeSubscribe(id uint16, codes []ReasonCode, props packets.Properties) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	sub, ok := pc.pendingSubs[id]
+	if !ok {
+		return
+	}
+	delete(pc.pendingSubs, id)
+
+	if len(sub.topics) != len(codes) {
+		sub.err = fmt.Errorf("%w : suback carries %d codes for %d topics", ErrMalformedPacket, len(codes), len(sub.topics))
+		close(sub.done)
+		return
+	}
+
+	for idx, topic := range sub.topics {
+		if codes[idx].IsError() {
+			pc.logger.Warn("subscription refused", "filter", topic, "reason_code", int(codes[idx]))
+			if sub.err == nil {
+				sub.err = newReasonError(CodeSubAck, codes[idx], props)
+			}
+			continue
+		}
+		pc.subscribed[topic] = uint8(codes[idx])
+		pc.logger.Debug("subscribed", "filter", topic, "qos", int(codes[idx]))
+	}
+	close(sub.done)
+}
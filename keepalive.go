@@ -0,0 +1,63 @@
+package portergosdk
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrKeepAliveTimeout = errors.New("no PINGRESP received within ping timeout")
+
+const (
+	defaultPingTimeout = 10 * time.Second
+	minKeepAliveTick   = 100 * time.Millisecond
+)
+
+// WithPingTimeout sets how long the client waits for a PINGRESP before
+// declaring the connection dead.
+func WithPingTimeout(timeout time.Duration) Option {
+	return func(c *PorterClient) {
+		c.pingTimeout = timeout
+	}
+}
+
+// keepAliveLoop sends a PINGREQ once the keep alive elapsed without outbound
+// traffic and closes the connection when its PINGRESP does not come back.
+func (pc *PorterClient) keepAliveLoop(c *connection) {
+	if c.keepAlive == 0 {
+		return
+	}
+
+	tick := min(c.keepAlive, pc.pingTimeout) / 4
+	if tick < minKeepAliveTick {
+		tick = minKeepAliveTick
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if sent := c.pingSent.Load(); sent > 0 {
+				if now.Sub(time.Unix(0, sent)) >= pc.pingTimeout {
+					pc.connectionLost(c, fmt.Errorf("%w : %w", ErrConnectionLost, ErrKeepAliveTimeout))
+					return
+				}
+				continue
+			}
+
+			if now.Sub(time.Unix(0, c.lastSent.Load())) < c.keepAlive {
+				continue
+			}
+
+			c.pingSent.Store(now.UnixNano())
+			if err := c.write([]byte{pingreqcmd, 0}); err != nil {
+				pc.connectionLost(c, fmt.Errorf("%w : %w", ErrConnectionLost, err))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package portergosdk
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/macdaih/porter_go_sdk/packets"
+)
+
+// WithLogger sends the client events to logger: connections, packets sent
+// and received, QoS exchanges, reconnects and errors. Packet level events
+// are logged at debug level. Payloads are redacted unless
+// WithPayloadLogging is set.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *PorterClient) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+// WithPayloadLogging logs up to max bytes of the payloads of the messages
+// sent and received, which may expose sensitive data.
+func WithPayloadLogging(max int) Option {
+	return func(c *PorterClient) {
+		c.payloadLogMax = max
+	}
+}
+
+// discardHandler drops every record, the client logs nothing without
+// WithLogger.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// payloadAttr describes a payload, only its size unless payload logging is
+// enabled.
+func (pc *PorterClient) payloadAttr(payload []byte) slog.Attr {
+	if pc.payloadLogMax <= 0 {
+		return slog.Group("payload", slog.Int("size", len(payload)), slog.Bool("redacted", true))
+	}
+
+	shown := payload
+	if len(shown) > pc.payloadLogMax {
+		shown = shown[:pc.payloadLogMax]
+	}
+	return slog.Group("payload", slog.Int("size", len(payload)), slog.String("data", string(shown)))
+}
+
+// frameType names the control packet an encoded frame holds.
+func frameType(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return packets.Type(b[0] >> 4).String()
+}
@@ -0,0 +1,200 @@
+package portergosdk
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/macdaih/porter_go_sdk/topic"
+)
+
+const defaultMessagesBuffer = 64
+
+type MessagesOptions struct {
+	// Buffer is the capacity of the channel, 64 when unset.
+	Buffer int
+	// Overflow decides what happens to a message arriving while the buffer
+	// is full. Block holds the connection reader, keepalives included, until
+	// the consumer catches up.
+	Overflow OverflowPolicy
+}
+
+// Subscription delivers the messages matching a topic filter on a channel.
+type Subscription struct {
+	filter string
+	opts   MessagesOptions
+
+	// mu is held while a message is sent so C is never closed under a sender
+	mu     sync.Mutex
+	c      chan AppMessage
+	closed chan struct{}
+	once   sync.Once
+
+	pc *PorterClient
+}
+
+func (s *Subscription) Filter() string {
+	return s.filter
+}
+
+// C returns the channel of messages, closed by Close or once the client
+// stops.
+func (s *Subscription) C() <-chan AppMessage {
+	return s.c
+}
+
+// Close stops the delivery and closes C. The server side subscription is
+// kept.
+func (s *Subscription) Close() {
+	s.pc.mu.Lock()
+	delete(s.pc.channels, s)
+	s.pc.mu.Unlock()
+
+	s.close()
+}
+
+func (s *Subscription) close() {
+	s.once.Do(func() {
+		close(s.closed)
+
+		s.mu.Lock()
+		close(s.c)
+		s.mu.Unlock()
+	})
+}
+
+func (s *Subscription) deliver(ctx context.Context, msg AppMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if isClosed(s.closed) {
+		return
+	}
+
+	switch s.opts.Overflow {
+	case Block:
+		select {
+		case s.c <- msg:
+		case <-s.closed:
+		case <-ctx.Done():
+		}
+	case DropNewest:
+		select {
+		case s.c <- msg:
+		default:
+		}
+	default:
+		for {
+			select {
+			case s.c <- msg:
+				return
+			default:
+			}
+
+			// make room by discarding the oldest buffered message
+			select {
+			case <-s.c:
+			default:
+			}
+		}
+	}
+}
+
+// Messages subscribes to filter unless already subscribed and returns a
+// Subscription receiving the matching messages, the WithCallBack handler
+// still being called for every message.
+func (pc *PorterClient) Messages(ctx context.Context, filter string, opts MessagesOptions) (*Subscription, error) {
+	connCtx, cancel := withTimedContext(ctx, pc.sessionDuration)
+	defer cancel()
+
+	if opts.Buffer <= 0 {
+		opts.Buffer = defaultMessagesBuffer
+	}
+
+	s := &Subscription{
+		filter: filter,
+		opts:   opts,
+		c:      make(chan AppMessage, opts.Buffer),
+		closed: make(chan struct{}),
+		pc:     pc,
+	}
+
+	// the channel is registered first as the server may send the retained
+	// messages right after SUBACK
+	pc.mu.Lock()
+	pc.channels[s] = struct{}{}
+	pc.mu.Unlock()
+
+	if err := pc.subscribe(connCtx, []string{filter}); err != nil {
+		pc.mu.Lock()
+		delete(pc.channels, s)
+		pc.mu.Unlock()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// dispatch hands an inbound message to the matching subscriptions and routes,
+// then to the callback, and reports whether they all succeeded. A handler
+// error or panic is logged.
+func (pc *PorterClient) dispatch(ctx context.Context, msg AppMessage) bool {
+	if pc.completeRequest(msg) {
+		return true
+	}
+
+	pc.mu.Lock()
+	subs := make([]*Subscription, 0, len(pc.channels))
+	for s := range pc.channels {
+		if topic.Match(s.filter, msg.TopicName) {
+			subs = append(subs, s)
+		}
+	}
+	routes := make([]Handler, 0, len(pc.routes))
+	for _, r := range pc.routes {
+		if topic.Match(r.filter, msg.TopicName) {
+			routes = append(routes, r.handler)
+		}
+	}
+	pc.mu.Unlock()
+
+	for _, s := range subs {
+		s.deliver(ctx, msg)
+	}
+
+	for _, h := range routes {
+		if err := callHandler(ctx, h, msg); err != nil {
+			pc.logger.Error("message handler failed", "topic", msg.TopicName, "error", err)
+			return false
+		}
+	}
+
+	if pc.messageHandler == nil {
+		return true
+	}
+	if err := callHandler(ctx, pc.messageHandler, msg); err != nil {
+		pc.logger.Error("message handler failed", "topic", msg.TopicName, "error", err)
+		return false
+	}
+	return true
+}
+
+// callHandler calls h, turning a panic into an error.
+func callHandler(ctx context.Context, h Handler, msg AppMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("message handler panicked : %v", r)
+		}
+	}()
+
+	return h(ctx, msg)
+}
+
+// closeChannels ends every subscription once the client stops, pc.mu must be
+// held.
+func (pc *PorterClient) closeChannels() {
+	for s := range pc.channels {
+		s.close()
+		delete(pc.channels, s)
+	}
+}
@@ -0,0 +1,170 @@
+package portergosdk
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// Handler processes an inbound message. An error is logged and the message
+// acknowledged with Unspecified error.
+type Handler func(ctx context.Context, msg AppMessage) error
+
+// Middleware wraps a Handler, the first of a chain being the outermost.
+type Middleware func(Handler) Handler
+
+func chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// WithMiddleware wraps the WithCallBack handler and every route handler.
+func WithMiddleware(mws ...Middleware) Option {
+	return func(c *PorterClient) {
+		c.middlewares = append(c.middlewares, mws...)
+	}
+}
+
+type route struct {
+	filter  string
+	handler Handler
+}
+
+// Handle subscribes to filter unless already subscribed and calls h, wrapped
+// in the client middlewares then mws, for every matching message. Routes run
+// in registration order before the WithCallBack handler, the first error
+// stopping the chain, and are subject to the same restrictions.
+func (pc *PorterClient) Handle(ctx context.Context, filter string, h Handler, mws ...Middleware) error {
+	connCtx, cancel := withTimedContext(ctx, pc.sessionDuration)
+	defer cancel()
+
+	r := &route{
+		filter:  filter,
+		handler: chain(chain(h, mws...), pc.middlewares...),
+	}
+
+	// the route is registered first as the server may send the retained
+	// messages right after SUBACK
+	pc.mu.Lock()
+	pc.routes = append(pc.routes, r)
+	pc.mu.Unlock()
+
+	if err := pc.subscribe(connCtx, []string{filter}); err != nil {
+		pc.mu.Lock()
+		pc.routes = slices.DeleteFunc(pc.routes, func(other *route) bool { return other == r })
+		pc.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// Recover turns a handler panic into an error.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg AppMessage) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("message handler panicked : %v", r)
+				}
+			}()
+
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Timing reports the duration and the outcome of every handler call.
+func Timing(report func(msg AppMessage, elapsed time.Duration, err error)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg AppMessage) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			report(msg, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// Logging logs every handled message with its topic and duration, payloads
+// are never logged.
+func Logging(logger *slog.Logger) Middleware {
+	return Timing(func(msg AppMessage, elapsed time.Duration, err error) {
+		if err != nil {
+			logger.Error("message handler failed", "topic", msg.TopicName, "elapsed", elapsed, "error", err)
+			return
+		}
+		logger.Debug("message handled", "topic", msg.TopicName, "elapsed", elapsed)
+	})
+}
+
+// Retry calls the handler up to attempts times while it fails, waiting
+// backoff before the first retry and doubling it on each one. The handler is
+// always called at least once.
+func Retry(attempts int, backoff time.Duration) Middleware {
+	attempts = max(attempts, 1)
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg AppMessage) error {
+			wait := backoff
+
+			var err error
+			for attempt := 0; attempt < attempts; attempt++ {
+				if attempt > 0 {
+					timer := time.NewTimer(wait)
+					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return err
+					case <-timer.C:
+					}
+					wait *= 2
+				}
+
+				if err = next(ctx, msg); err == nil {
+					return nil
+				}
+			}
+
+			return err
+		}
+	}
+}
+
+const (
+	DeadLetterErrorProperty = "dead-letter-error"
+	DeadLetterTopicProperty = "dead-letter-topic"
+)
+
+// DeadLetter republishes the messages a handler failed on to topic, the
+// error and the original topic added as user properties, and acknowledges
+// them. The message is published on its own goroutine so the handler never
+// waits on the connection reader, a dead letter that could not be published
+// being logged.
+func (pc *PorterClient) DeadLetter(topic string) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg AppMessage) error {
+			herr := next(ctx, msg)
+			if herr == nil {
+				return nil
+			}
+
+			dead := msg
+			dead.TopicName = topic
+			dead.UserProperties = append(
+				append([]UserProperty(nil), msg.UserProperties...),
+				UserProperty{Key: DeadLetterErrorProperty, Value: herr.Error()},
+				UserProperty{Key: DeadLetterTopicProperty, Value: msg.TopicName},
+			)
+
+			if err := pc.publishNoWait(ctx, dead); err != nil {
+				return fmt.Errorf("failed to dead-letter message : %w : %w", err, herr)
+			}
+			return nil
+		}
+	}
+}
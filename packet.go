@@ -1,9 +1,9 @@
 package portergosdk
 
 import (
-	"bytes"
 	"errors"
-	"fmt"
+
+	"github.com/macdaih/porter_go_sdk/packets"
 )
 
 type packetType byte
@@ -26,143 +26,8 @@ const (
 	authcmd        = 0xF0
 )
 
-type packet struct {
-	cmd    packetType
-	flags  byte
-	buffer *bytes.Buffer
-	length int
-}
-
 var (
-	ErrInvalidCommand  error = errors.New("unrecognized packet command")
+	ErrInvalidCommand  error = packets.ErrInvalidType
 	ErrInvalidLength   error = errors.New("invalid length read")
-	ErrMalformedPacket error = errors.New("malformed packet")
+	ErrMalformedPacket error = packets.ErrMalformedPacket
 )
-
-func validateType(t byte) (packetType, error) {
-	pt := packetType(t)
-	switch pt {
-	case
-		connectcmd,
-		connackcmd,
-		publishcmd,
-		pubackcmd,
-		pubreccmd,
-		pubrelcmd,
-		pubcompcmd,
-		subscribecmd,
-		subackcmd,
-		unsubscribecmd,
-		unsubackcmd,
-		pingreqcmd,
-		pingrespcmd,
-		disconnectcmd,
-		authcmd:
-		return pt, nil
-	default:
-		return 0x00, ErrInvalidCommand
-	}
-}
-
-func newPacket(buff []byte) (*packet, error) {
-	fmt.Printf("dbg raw : \n%v\n", buff)
-	cmd := buff[0] & 0xf0
-	flags := buff[0] & 0x0f
-
-	pt, err := validateType(cmd)
-	if err != nil {
-		return nil, err
-	}
-
-	length, err := decodeVarint(buff[1:])
-	if err != nil {
-		return nil, err
-	}
-
-	iLen := int(length)
-	remainingLen := evalBytes(length)
-	fheaderLen := remainingLen + 1
-	if iLen > len(buff) {
-		return nil, ErrMalformedPacket
-	}
-
-	buff = buff[fheaderLen:]
-	if fheaderLen < iLen {
-		buff = buff[:iLen]
-	}
-
-	return &packet{
-		cmd:    pt,
-		flags:  flags,
-		buffer: bytes.NewBuffer(buff),
-		length: remainingLen,
-	}, nil
-}
-
-func (pkt *packet) readByte() (byte, error) {
-	return pkt.buffer.ReadByte()
-}
-
-func (pkt *packet) readVarint() (uint32, error) {
-	v, err := decodeVarint(pkt.buffer.Bytes())
-	if err != nil {
-		return 0, err
-	}
-
-	_ = pkt.buffer.Next(evalBytes(v))
-	return v, nil
-}
-
-func (pkt *packet) readString() (string, error) {
-	s, err := readUTFString(pkt.buffer.Bytes())
-	if err != nil {
-		return "", err
-	}
-
-	_ = pkt.buffer.Next(2 + len(s))
-
-	return s, nil
-}
-
-func (pkt *packet) readUint32() (uint32, error) {
-	ui, err := readUint32(pkt.buffer.Bytes())
-	if err != nil {
-		return 0, err
-	}
-
-	_ = pkt.buffer.Next(4)
-	return ui, nil
-}
-
-func (pkt *packet) readUint16() (uint16, error) {
-	ui, err := readUint16(pkt.buffer.Bytes())
-	if err != nil {
-		return 0, err
-	}
-
-	_ = pkt.buffer.Next(2)
-	return ui, nil
-}
-
-func (pkt *packet) readProperties(max int) ([]property, error) {
-	properties := make([]property, 0, max)
-	propsLen, err := pkt.readVarint()
-	if err != nil {
-		return nil, err
-	}
-
-	if int(propsLen) > pkt.buffer.Len() {
-		return nil, ErrInvalidLength
-	}
-
-	cursor := 0
-	for cursor < int(propsLen) {
-		prop, err := readProperty(pkt)
-		if err != nil {
-			return nil, err
-		}
-		properties = append(properties, prop)
-		cursor += prop.size + 1
-	}
-	return properties, nil
-}
@@ -0,0 +1,149 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Ack is the layout shared by PUBACK, PUBREC, PUBREL and PUBCOMP.
+type Ack struct {
+	PacketID   uint16
+	ReasonCode byte
+	Properties Properties
+}
+
+func (a *Ack) encode(v Version, t Type) ([]byte, error) {
+	var buff bytes.Buffer
+	writeUint16(&buff, a.PacketID)
+
+	// MQTT 3.1.1 acknowledgements only carry the packet identifier
+	if v < V5 {
+		if a.ReasonCode != 0 {
+			return nil, fmt.Errorf("%w : %s reason code", ErrVersion, t)
+		}
+		return buff.Bytes(), noProperties(&a.Properties, v, t)
+	}
+
+	var props bytes.Buffer
+	if err := a.Properties.encode(&props, v, t); err != nil {
+		return nil, err
+	}
+
+	// reason code and properties can be left out on success
+	if a.ReasonCode != 0 || props.Len() > 1 {
+		buff.WriteByte(a.ReasonCode)
+		if props.Len() > 1 {
+			buff.Write(props.Bytes())
+		}
+	}
+
+	return buff.Bytes(), nil
+}
+
+func (a *Ack) decode(t Type, d *decoder) error {
+	var err error
+	if a.PacketID, err = d.uint16(); err != nil {
+		return err
+	}
+
+	a.ReasonCode = 0
+	a.Properties = Properties{}
+	if d.len() == 0 {
+		return nil
+	}
+
+	if d.version < V5 {
+		return fmt.Errorf("%w : %s with %d extra bytes", ErrMalformedPacket, t, d.len())
+	}
+
+	if a.ReasonCode, err = d.byte(); err != nil {
+		return err
+	}
+	if d.len() == 0 {
+		return nil
+	}
+
+	a.Properties, err = decodeProperties(d, t)
+	return err
+}
+
+type Puback struct{ Ack }
+
+func (p *Puback) Type() Type { return TypePuback }
+
+func (p *Puback) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Puback) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Puback) encodeBody(v Version) (byte, []byte, error) {
+	b, err := p.Ack.encode(v, TypePuback)
+	return 0, b, err
+}
+
+func (p *Puback) decodeBody(flags byte, d *decoder) error {
+	if err := expectFlags(TypePuback, flags, 0); err != nil {
+		return err
+	}
+	return p.Ack.decode(TypePuback, d)
+}
+
+type Pubrec struct{ Ack }
+
+func (p *Pubrec) Type() Type { return TypePubrec }
+
+func (p *Pubrec) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Pubrec) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Pubrec) encodeBody(v Version) (byte, []byte, error) {
+	b, err := p.Ack.encode(v, TypePubrec)
+	return 0, b, err
+}
+
+func (p *Pubrec) decodeBody(flags byte, d *decoder) error {
+	if err := expectFlags(TypePubrec, flags, 0); err != nil {
+		return err
+	}
+	return p.Ack.decode(TypePubrec, d)
+}
+
+type Pubrel struct{ Ack }
+
+func (p *Pubrel) Type() Type { return TypePubrel }
+
+func (p *Pubrel) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Pubrel) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Pubrel) encodeBody(v Version) (byte, []byte, error) {
+	b, err := p.Ack.encode(v, TypePubrel)
+	return 0x02, b, err
+}
+
+func (p *Pubrel) decodeBody(flags byte, d *decoder) error {
+	if err := expectFlags(TypePubrel, flags, 0x02); err != nil {
+		return err
+	}
+	return p.Ack.decode(TypePubrel, d)
+}
+
+type Pubcomp struct{ Ack }
+
+func (p *Pubcomp) Type() Type { return TypePubcomp }
+
+func (p *Pubcomp) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Pubcomp) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Pubcomp) encodeBody(v Version) (byte, []byte, error) {
+	b, err := p.Ack.encode(v, TypePubcomp)
+	return 0, b, err
+}
+
+func (p *Pubcomp) decodeBody(flags byte, d *decoder) error {
+	if err := expectFlags(TypePubcomp, flags, 0); err != nil {
+		return err
+	}
+	return p.Ack.decode(TypePubcomp, d)
+}
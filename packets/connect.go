@@ -0,0 +1,233 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+type Will struct {
+	QoS        byte
+	Retain     bool
+	Properties Properties
+	Topic      string
+	Payload    []byte
+}
+
+type Connect struct {
+	ProtocolName    string
+	ProtocolVersion byte
+	CleanStart      bool
+	KeepAlive       uint16
+	Properties      Properties
+	ClientID        string
+	Will            *Will
+	Username        *string
+	Password        []byte
+}
+
+func (p *Connect) Type() Type { return TypeConnect }
+
+func (p *Connect) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Connect) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Connect) encodeBody(v Version) (byte, []byte, error) {
+	var buff bytes.Buffer
+
+	name, version := p.ProtocolName, Version(p.ProtocolVersion)
+	if name == "" {
+		name = "MQTT"
+	}
+	if version == 0 {
+		version = v
+	}
+	// the protocol level of CONNECT sets the version of its own encoding
+	v = version
+
+	if v < V5 && p.Password != nil && p.Username == nil {
+		return 0, nil, fmt.Errorf("%w : password without user name", ErrVersion)
+	}
+
+	if err := writeString(&buff, name); err != nil {
+		return 0, nil, err
+	}
+	buff.WriteByte(byte(version))
+
+	var flags byte
+	if p.CleanStart {
+		flags |= 0x02
+	}
+	if p.Will != nil {
+		if p.Will.QoS > 2 {
+			return 0, nil, fmt.Errorf("%w : will QoS %d", ErrProtocolError, p.Will.QoS)
+		}
+		flags |= 0x04 | p.Will.QoS<<3
+		if p.Will.Retain {
+			flags |= 0x20
+		}
+	}
+	if p.Password != nil {
+		flags |= 0x40
+	}
+	if p.Username != nil {
+		flags |= 0x80
+	}
+	buff.WriteByte(flags)
+	writeUint16(&buff, p.KeepAlive)
+
+	if err := p.Properties.encode(&buff, v, TypeConnect); err != nil {
+		return 0, nil, err
+	}
+
+	if err := writeString(&buff, p.ClientID); err != nil {
+		return 0, nil, err
+	}
+
+	if p.Will != nil {
+		if err := p.Will.Properties.encode(&buff, v, willProperties); err != nil {
+			return 0, nil, err
+		}
+		if err := writeString(&buff, p.Will.Topic); err != nil {
+			return 0, nil, err
+		}
+		if err := writeBinary(&buff, p.Will.Payload); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if p.Username != nil {
+		if err := writeString(&buff, *p.Username); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if p.Password != nil {
+		if err := writeBinary(&buff, p.Password); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return 0, buff.Bytes(), nil
+}
+
+func (p *Connect) decodeBody(flags byte, d *decoder) error {
+	if err := expectFlags(TypeConnect, flags, 0); err != nil {
+		return err
+	}
+
+	var err error
+	if p.ProtocolName, err = d.string(); err != nil {
+		return err
+	}
+	if p.ProtocolVersion, err = d.byte(); err != nil {
+		return err
+	}
+	d.version = Version(p.ProtocolVersion)
+
+	connectFlags, err := d.byte()
+	if err != nil {
+		return err
+	}
+	if connectFlags&0x01 != 0 {
+		return fmt.Errorf("%w : reserved connect flag set", ErrMalformedPacket)
+	}
+	p.CleanStart = connectFlags&0x02 != 0
+
+	if p.KeepAlive, err = d.uint16(); err != nil {
+		return err
+	}
+	if p.Properties, err = decodeProperties(d, TypeConnect); err != nil {
+		return err
+	}
+	if p.ClientID, err = d.string(); err != nil {
+		return err
+	}
+
+	p.Will = nil
+	if connectFlags&0x04 != 0 {
+		w := Will{
+			QoS:    (connectFlags >> 3) & 0x03,
+			Retain: connectFlags&0x20 != 0,
+		}
+		if w.QoS > 2 {
+			return fmt.Errorf("%w : will QoS %d", ErrMalformedPacket, w.QoS)
+		}
+		if w.Properties, err = decodeProperties(d, willProperties); err != nil {
+			return err
+		}
+		if w.Topic, err = d.string(); err != nil {
+			return err
+		}
+		if w.Payload, err = d.binary(); err != nil {
+			return err
+		}
+		p.Will = &w
+	} else if connectFlags&0x38 != 0 {
+		return fmt.Errorf("%w : will QoS or retain set without a will", ErrMalformedPacket)
+	}
+
+	p.Username = nil
+	if connectFlags&0x80 != 0 {
+		usr, err := d.string()
+		if err != nil {
+			return err
+		}
+		p.Username = &usr
+	}
+
+	p.Password = nil
+	if connectFlags&0x40 != 0 {
+		if p.Password, err = d.binary(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type Connack struct {
+	SessionPresent bool
+	ReasonCode     byte
+	Properties     Properties
+}
+
+func (p *Connack) Type() Type { return TypeConnack }
+
+func (p *Connack) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Connack) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Connack) encodeBody(v Version) (byte, []byte, error) {
+	var buff bytes.Buffer
+
+	buff.WriteByte(boolByte(p.SessionPresent))
+	buff.WriteByte(p.ReasonCode)
+	if err := p.Properties.encode(&buff, v, TypeConnack); err != nil {
+		return 0, nil, err
+	}
+
+	return 0, buff.Bytes(), nil
+}
+
+func (p *Connack) decodeBody(flags byte, d *decoder) error {
+	if err := expectFlags(TypeConnack, flags, 0); err != nil {
+		return err
+	}
+
+	ack, err := d.byte()
+	if err != nil {
+		return err
+	}
+	if ack&0xfe != 0 {
+		return fmt.Errorf("%w : reserved acknowledge flags set", ErrMalformedPacket)
+	}
+	p.SessionPresent = ack == 1
+
+	if p.ReasonCode, err = d.byte(); err != nil {
+		return err
+	}
+
+	p.Properties, err = decodeProperties(d, TypeConnack)
+	return err
+}
@@ -0,0 +1,140 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+type Pingreq struct{}
+
+func (p *Pingreq) Type() Type { return TypePingreq }
+
+func (p *Pingreq) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Pingreq) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Pingreq) encodeBody(v Version) (byte, []byte, error) { return 0, nil, nil }
+
+func (p *Pingreq) decodeBody(flags byte, d *decoder) error {
+	return emptyBody(TypePingreq, flags, d)
+}
+
+type Pingresp struct{}
+
+func (p *Pingresp) Type() Type { return TypePingresp }
+
+func (p *Pingresp) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Pingresp) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Pingresp) encodeBody(v Version) (byte, []byte, error) { return 0, nil, nil }
+
+func (p *Pingresp) decodeBody(flags byte, d *decoder) error {
+	return emptyBody(TypePingresp, flags, d)
+}
+
+func emptyBody(t Type, flags byte, d *decoder) error {
+	if err := expectFlags(t, flags, 0); err != nil {
+		return err
+	}
+	if d.len() > 0 {
+		return fmt.Errorf("%w : %s with %d bytes of payload", ErrMalformedPacket, t, d.len())
+	}
+	return nil
+}
+
+type Disconnect struct {
+	ReasonCode byte
+	Properties Properties
+}
+
+func (p *Disconnect) Type() Type { return TypeDisconnect }
+
+func (p *Disconnect) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Disconnect) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Disconnect) encodeBody(v Version) (byte, []byte, error) {
+	b, err := encodeReason(v, TypeDisconnect, p.ReasonCode, &p.Properties)
+	return 0, b, err
+}
+
+func (p *Disconnect) decodeBody(flags byte, d *decoder) error {
+	if err := expectFlags(TypeDisconnect, flags, 0); err != nil {
+		return err
+	}
+
+	var err error
+	p.ReasonCode, p.Properties, err = decodeReason(TypeDisconnect, d)
+	return err
+}
+
+type Auth struct {
+	ReasonCode byte
+	Properties Properties
+}
+
+func (p *Auth) Type() Type { return TypeAuth }
+
+func (p *Auth) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Auth) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Auth) encodeBody(v Version) (byte, []byte, error) {
+	b, err := encodeReason(v, TypeAuth, p.ReasonCode, &p.Properties)
+	return 0, b, err
+}
+
+func (p *Auth) decodeBody(flags byte, d *decoder) error {
+	if err := expectFlags(TypeAuth, flags, 0); err != nil {
+		return err
+	}
+
+	var err error
+	p.ReasonCode, p.Properties, err = decodeReason(TypeAuth, d)
+	return err
+}
+
+// encodeReason writes the layout shared by DISCONNECT and AUTH, which may be
+// empty for a success without properties.
+func encodeReason(v Version, t Type, code byte, props *Properties) ([]byte, error) {
+	if v < V5 {
+		if code != 0 {
+			return nil, fmt.Errorf("%w : %s reason code", ErrVersion, t)
+		}
+		return nil, noProperties(props, v, t)
+	}
+
+	var buff bytes.Buffer
+	if err := props.encode(&buff, v, t); err != nil {
+		return nil, err
+	}
+
+	if code == 0 && buff.Len() == 1 {
+		return nil, nil
+	}
+
+	return append([]byte{code}, buff.Bytes()...), nil
+}
+
+func decodeReason(t Type, d *decoder) (byte, Properties, error) {
+	if d.len() == 0 {
+		return 0, Properties{}, nil
+	}
+
+	if d.version < V5 {
+		return 0, Properties{}, fmt.Errorf("%w : %s with payload", ErrMalformedPacket, t)
+	}
+
+	code, err := d.byte()
+	if err != nil {
+		return 0, Properties{}, err
+	}
+	if d.len() == 0 {
+		return code, Properties{}, nil
+	}
+
+	props, err := decodeProperties(d, t)
+	return code, props, err
+}
@@ -0,0 +1,182 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+func writeUint16(buff *bytes.Buffer, v uint16) {
+	buff.WriteByte(byte(v >> 8))
+	buff.WriteByte(byte(v))
+}
+
+func writeUint32(buff *bytes.Buffer, v uint32) {
+	buff.WriteByte(byte(v >> 24))
+	buff.WriteByte(byte(v >> 16))
+	buff.WriteByte(byte(v >> 8))
+	buff.WriteByte(byte(v))
+}
+
+func writeVarint(buff *bytes.Buffer, v int) {
+	for {
+		b := byte(v % 128)
+		v /= 128
+		if v > 0 {
+			b |= 0x80
+		}
+		buff.WriteByte(b)
+		if v == 0 {
+			return
+		}
+	}
+}
+
+func varintSize(v int) int {
+	switch {
+	case v < 128:
+		return 1
+	case v < 16384:
+		return 2
+	case v < 2097152:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func writeString(buff *bytes.Buffer, s string) error {
+	if len(s) > math.MaxUint16 {
+		return fmt.Errorf("%w : string of %d bytes exceeds 65535", ErrMalformedPacket, len(s))
+	}
+	writeUint16(buff, uint16(len(s)))
+	buff.WriteString(s)
+	return nil
+}
+
+func writeBinary(buff *bytes.Buffer, b []byte) error {
+	if len(b) > math.MaxUint16 {
+		return fmt.Errorf("%w : binary data of %d bytes exceeds 65535", ErrMalformedPacket, len(b))
+	}
+	writeUint16(buff, uint16(len(b)))
+	buff.Write(b)
+	return nil
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// decoder reads the variable header and payload of a packet.
+type decoder struct {
+	buf     []byte
+	off     int
+	version Version
+}
+
+func newDecoder(b []byte) *decoder {
+	return &decoder{buf: b, version: V5}
+}
+
+func (d *decoder) len() int {
+	return len(d.buf) - d.off
+}
+
+func (d *decoder) short(n int) error {
+	return fmt.Errorf("%w : %d bytes missing", ErrMalformedPacket, n-d.len())
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.len() < 1 {
+		return 0, d.short(1)
+	}
+	b := d.buf[d.off]
+	d.off++
+	return b, nil
+}
+
+func (d *decoder) uint16() (uint16, error) {
+	if d.len() < 2 {
+		return 0, d.short(2)
+	}
+	v := uint16(d.buf[d.off])<<8 | uint16(d.buf[d.off+1])
+	d.off += 2
+	return v, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	if d.len() < 4 {
+		return 0, d.short(4)
+	}
+	v := uint32(d.buf[d.off])<<24 |
+		uint32(d.buf[d.off+1])<<16 |
+		uint32(d.buf[d.off+2])<<8 |
+		uint32(d.buf[d.off+3])
+	d.off += 4
+	return v, nil
+}
+
+func (d *decoder) varint() (int, error) {
+	var (
+		v          int
+		multiplier = 1
+	)
+	for i := 0; i < 4; i++ {
+		b, err := d.byte()
+		if err != nil {
+			return 0, err
+		}
+		v += int(b&127) * multiplier
+		multiplier *= 128
+		if b&128 == 0 {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("%w : variable byte integer exceeds 4 bytes", ErrMalformedPacket)
+}
+
+func (d *decoder) bytes(n int) ([]byte, error) {
+	if d.len() < n {
+		return nil, d.short(n)
+	}
+	b := d.buf[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
+
+func (d *decoder) binary() ([]byte, error) {
+	n, err := d.uint16()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := d.bytes(int(n))
+	if err != nil {
+		return nil, err
+	}
+
+	// copy so the packet does not retain the read buffer
+	return append([]byte(nil), b...), nil
+}
+
+func (d *decoder) string() (string, error) {
+	n, err := d.uint16()
+	if err != nil {
+		return "", err
+	}
+
+	b, err := d.bytes(int(n))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *decoder) rest() []byte {
+	b := d.buf[d.off:]
+	d.off = len(d.buf)
+	return append([]byte(nil), b...)
+}
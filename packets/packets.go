@@ -0,0 +1,310 @@
+// Package packets encodes and decodes MQTT 5 and MQTT 3.1.1 control packets.
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Version is the protocol level carried by CONNECT.
+type Version byte
+
+const (
+	V311 Version = 4
+	V5   Version = 5
+)
+
+type Type byte
+
+const (
+	TypeConnect     Type = 0x01
+	TypeConnack     Type = 0x02
+	TypePublish     Type = 0x03
+	TypePuback      Type = 0x04
+	TypePubrec      Type = 0x05
+	TypePubrel      Type = 0x06
+	TypePubcomp     Type = 0x07
+	TypeSubscribe   Type = 0x08
+	TypeSuback      Type = 0x09
+	TypeUnsubscribe Type = 0x0A
+	TypeUnsuback    Type = 0x0B
+	TypePingreq     Type = 0x0C
+	TypePingresp    Type = 0x0D
+	TypeDisconnect  Type = 0x0E
+	TypeAuth        Type = 0x0F
+)
+
+var typeNames = map[Type]string{
+	TypeConnect:     "CONNECT",
+	TypeConnack:     "CONNACK",
+	TypePublish:     "PUBLISH",
+	TypePuback:      "PUBACK",
+	TypePubrec:      "PUBREC",
+	TypePubrel:      "PUBREL",
+	TypePubcomp:     "PUBCOMP",
+	TypeSubscribe:   "SUBSCRIBE",
+	TypeSuback:      "SUBACK",
+	TypeUnsubscribe: "UNSUBSCRIBE",
+	TypeUnsuback:    "UNSUBACK",
+	TypePingreq:     "PINGREQ",
+	TypePingresp:    "PINGRESP",
+	TypeDisconnect:  "DISCONNECT",
+	TypeAuth:        "AUTH",
+}
+
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(0x%02X)", byte(t))
+}
+
+var (
+	ErrInvalidType     = errors.New("unrecognized packet type")
+	ErrMalformedPacket = errors.New("malformed packet")
+	ErrProtocolError   = errors.New("protocol error")
+	ErrPacketTooLarge  = errors.New("packet exceeds maximum packet size")
+	ErrVersion         = errors.New("not supported by the protocol version")
+)
+
+// MaxRemainingLength is the largest value a variable byte integer can hold.
+const MaxRemainingLength = 268435455
+
+type Packet interface {
+	Type() Type
+	// Encode writes the whole packet, fixed header included, as MQTT 5.
+	Encode(w io.Writer) error
+	// Decode reads a whole MQTT 5 packet, fixed header included.
+	Decode(r io.Reader) error
+}
+
+// body is implemented by every packet to share the fixed header handling.
+type body interface {
+	Packet
+	encodeBody(v Version) (byte, []byte, error)
+	decodeBody(flags byte, d *decoder) error
+}
+
+// Codec encodes and decodes packets for a given protocol version, MQTT 5
+// being used when Version is not set.
+type Codec struct {
+	Version Version
+	// MaxPacketSize refuses bigger inbound packets when it is not 0.
+	MaxPacketSize uint32
+}
+
+func (c Codec) version() Version {
+	if c.Version == 0 {
+		return V5
+	}
+	return c.Version
+}
+
+// ReadPacket reads the next packet from r. The remaining bytes of a packet
+// refused for its size are left unread.
+func (c Codec) ReadPacket(r io.Reader) (Packet, error) {
+	header, payload, err := readFrame(r, c.MaxPacketSize)
+	if err != nil {
+		return nil, err
+	}
+
+	pkt, err := New(Type(header >> 4))
+	if err != nil {
+		return nil, err
+	}
+
+	if pkt.Type() == TypeAuth && c.version() < V5 {
+		return nil, fmt.Errorf("%w : %s", ErrVersion, TypeAuth)
+	}
+
+	d := newDecoder(payload)
+	d.version = c.version()
+	if err := pkt.(body).decodeBody(header&0x0f, d); err != nil {
+		return nil, err
+	}
+
+	return pkt, nil
+}
+
+func (c Codec) Encode(w io.Writer, pkt Packet) error {
+	b, ok := pkt.(body)
+	if !ok {
+		return ErrInvalidType
+	}
+	return encode(w, c.version(), b)
+}
+
+func (c Codec) Marshal(pkt Packet) ([]byte, error) {
+	var buff bytes.Buffer
+	if err := c.Encode(&buff, pkt); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// Size returns the encoded size of a packet in bytes.
+func (c Codec) Size(pkt Packet) (int, error) {
+	b, ok := pkt.(body)
+	if !ok {
+		return 0, ErrInvalidType
+	}
+
+	_, payload, err := b.encodeBody(c.version())
+	if err != nil {
+		return 0, err
+	}
+
+	return 1 + varintSize(len(payload)) + len(payload), nil
+}
+
+// New returns an empty packet of the given type.
+func New(t Type) (Packet, error) {
+	switch t {
+	case TypeConnect:
+		return &Connect{}, nil
+	case TypeConnack:
+		return &Connack{}, nil
+	case TypePublish:
+		return &Publish{}, nil
+	case TypePuback:
+		return &Puback{}, nil
+	case TypePubrec:
+		return &Pubrec{}, nil
+	case TypePubrel:
+		return &Pubrel{}, nil
+	case TypePubcomp:
+		return &Pubcomp{}, nil
+	case TypeSubscribe:
+		return &Subscribe{}, nil
+	case TypeSuback:
+		return &Suback{}, nil
+	case TypeUnsubscribe:
+		return &Unsubscribe{}, nil
+	case TypeUnsuback:
+		return &Unsuback{}, nil
+	case TypePingreq:
+		return &Pingreq{}, nil
+	case TypePingresp:
+		return &Pingresp{}, nil
+	case TypeDisconnect:
+		return &Disconnect{}, nil
+	case TypeAuth:
+		return &Auth{}, nil
+	default:
+		return nil, ErrInvalidType
+	}
+}
+
+// ReadPacket reads the next MQTT 5 packet from r.
+func ReadPacket(r io.Reader) (Packet, error) {
+	return Codec{}.ReadPacket(r)
+}
+
+// ReadPacketLimit reads the next MQTT 5 packet from r, refusing packets
+// larger than max bytes when max is not 0.
+func ReadPacketLimit(r io.Reader, max uint32) (Packet, error) {
+	return Codec{MaxPacketSize: max}.ReadPacket(r)
+}
+
+// Size returns the encoded size of an MQTT 5 packet in bytes.
+func Size(pkt Packet) (int, error) {
+	return Codec{}.Size(pkt)
+}
+
+// Marshal returns the MQTT 5 encoding of a packet.
+func Marshal(pkt Packet) ([]byte, error) {
+	return Codec{}.Marshal(pkt)
+}
+
+func readFrame(r io.Reader, max uint32) (byte, []byte, error) {
+	var one [1]byte
+	if _, err := io.ReadFull(r, one[:]); err != nil {
+		return 0, nil, err
+	}
+	header := one[0]
+
+	var (
+		length     int
+		multiplier = 1
+		headerLen  = 1
+	)
+	for i := 0; ; i++ {
+		if i >= 4 {
+			return 0, nil, fmt.Errorf("%w : remaining length exceeds 4 bytes", ErrMalformedPacket)
+		}
+
+		if _, err := io.ReadFull(r, one[:]); err != nil {
+			return 0, nil, err
+		}
+		headerLen++
+
+		length += int(one[0]&127) * multiplier
+		multiplier *= 128
+		if one[0]&128 == 0 {
+			break
+		}
+	}
+
+	if max > 0 && headerLen+length > int(max) {
+		return 0, nil, fmt.Errorf("%w : %d bytes over %d", ErrPacketTooLarge, headerLen+length, max)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return header, payload, nil
+}
+
+func encode(w io.Writer, v Version, pkt body) error {
+	if pkt.Type() == TypeAuth && v < V5 {
+		return fmt.Errorf("%w : %s", ErrVersion, TypeAuth)
+	}
+
+	flags, payload, err := pkt.encodeBody(v)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > MaxRemainingLength {
+		return fmt.Errorf("%w : remaining length %d", ErrPacketTooLarge, len(payload))
+	}
+
+	var buff bytes.Buffer
+	buff.Grow(5 + len(payload))
+	buff.WriteByte(byte(pkt.Type())<<4 | flags&0x0f)
+	writeVarint(&buff, len(payload))
+	buff.Write(payload)
+
+	_, err = w.Write(buff.Bytes())
+	return err
+}
+
+func decode(r io.Reader, pkt body) error {
+	header, payload, err := readFrame(r, 0)
+	if err != nil {
+		return err
+	}
+
+	if Type(header>>4) != pkt.Type() {
+		return fmt.Errorf(
+			"%w : read %s while decoding %s",
+			ErrInvalidType,
+			Type(header>>4),
+			pkt.Type(),
+		)
+	}
+
+	return pkt.decodeBody(header&0x0f, newDecoder(payload))
+}
+
+// expectFlags checks the reserved bits of the fixed header.
+func expectFlags(t Type, flags byte, expected byte) error {
+	if flags != expected {
+		return fmt.Errorf("%w : invalid %s flags 0x%X", ErrMalformedPacket, t, flags)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func v5Packets() []Packet {
+	usr := "user"
+
+	return []Packet{
+		&Connect{
+			ProtocolName:    "MQTT",
+			ProtocolVersion: byte(V5),
+			CleanStart:      true,
+			KeepAlive:       30,
+			Properties: Properties{
+				SessionExpiryInterval:      ptr(uint32(60)),
+				ReceiveMaximum:             ptr(uint16(10)),
+				RequestResponseInformation: ptr(true),
+				UserProperties:             []UserProperty{{Key: "k", Value: "v"}},
+			},
+			ClientID: "client",
+			Will: &Will{
+				QoS:    1,
+				Retain: true,
+				Properties: Properties{
+					WillDelayInterval:      ptr(uint32(5)),
+					PayloadFormatIndicator: ptr(byte(1)),
+				},
+				Topic:   "will/topic",
+				Payload: []byte("bye"),
+			},
+			Username: &usr,
+			Password: []byte("pwd"),
+		},
+		&Connack{
+			SessionPresent: true,
+			Properties: Properties{
+				AssignedClientIdentifier: "assigned",
+				ServerKeepAlive:          ptr(uint16(20)),
+				ResponseInformation:      "replies",
+				TopicAliasMaximum:        ptr(uint16(8)),
+				MaximumPacketSize:        ptr(uint32(1024)),
+			},
+		},
+		&Publish{
+			Dup:      true,
+			QoS:      2,
+			Retain:   true,
+			Topic:    "a/b",
+			PacketID: 7,
+			Properties: Properties{
+				MessageExpiryInterval: ptr(uint32(10)),
+				ContentType:           "text/plain",
+				ResponseTopic:         "a/reply",
+				CorrelationData:       []byte("id"),
+				TopicAlias:            ptr(uint16(1)),
+			},
+			Payload: []byte("payload"),
+		},
+		&Puback{Ack{PacketID: 1, ReasonCode: 0x10, Properties: Properties{ReasonString: "no subscribers"}}},
+		&Pubrec{Ack{PacketID: 2, ReasonCode: 0x80}},
+		&Pubrel{Ack{PacketID: 3}},
+		&Pubcomp{Ack{PacketID: 4, ReasonCode: 0x92}},
+		&Subscribe{
+			PacketID:   5,
+			Properties: Properties{SubscriptionIdentifiers: []int{3}},
+			Subscriptions: []Subscription{
+				{Topic: "a/+", QoS: 1, NoLocal: true},
+				{Topic: "b/#", QoS: 2, RetainAsPublished: true, RetainHandling: 2},
+			},
+		},
+		&Suback{PacketID: 5, ReasonCodes: []byte{0x01, 0x87}},
+		&Unsubscribe{PacketID: 6, Topics: []string{"a/+", "b/#"}},
+		&Unsuback{PacketID: 6, Properties: Properties{ReasonString: "done"}, ReasonCodes: []byte{0x00, 0x11}},
+		&Pingreq{},
+		&Pingresp{},
+		&Disconnect{ReasonCode: 0x9C, Properties: Properties{ServerReference: "other:1883"}},
+		&Auth{ReasonCode: 0x18, Properties: Properties{AuthenticationMethod: "SCRAM", AuthenticationData: []byte("data")}},
+	}
+}
+
+func v311Packets() []Packet {
+	usr := "user"
+
+	return []Packet{
+		&Connect{
+			ProtocolName:    "MQTT",
+			ProtocolVersion: byte(V311),
+			CleanStart:      true,
+			KeepAlive:       30,
+			ClientID:        "client",
+			Will:            &Will{QoS: 2, Topic: "will/topic", Payload: []byte("bye")},
+			Username:        &usr,
+			Password:        []byte("pwd"),
+		},
+		&Connack{SessionPresent: true},
+		&Publish{Dup: true, QoS: 1, Retain: true, Topic: "a/b", PacketID: 7, Payload: []byte("payload")},
+		&Puback{Ack{PacketID: 1}},
+		&Pubrec{Ack{PacketID: 2}},
+		&Pubrel{Ack{PacketID: 3}},
+		&Pubcomp{Ack{PacketID: 4}},
+		&Subscribe{PacketID: 5, Subscriptions: []Subscription{{Topic: "a/+", QoS: 1}, {Topic: "b/#", QoS: 2}}},
+		&Suback{PacketID: 5, ReasonCodes: []byte{0x01, 0x80}},
+		&Unsubscribe{PacketID: 6, Topics: []string{"a/+", "b/#"}},
+		&Unsuback{PacketID: 6},
+		&Pingreq{},
+		&Pingresp{},
+		&Disconnect{},
+	}
+}
+
+func roundTrip(t *testing.T, v Version, packets []Packet) {
+	t.Helper()
+
+	codec := Codec{Version: v}
+	for _, pkt := range packets {
+		t.Run(pkt.Type().String(), func(t *testing.T) {
+			var buff bytes.Buffer
+			if err := codec.Encode(&buff, pkt); err != nil {
+				t.Fatalf("encode : %v", err)
+			}
+
+			size, err := codec.Size(pkt)
+			if err != nil {
+				t.Fatalf("size : %v", err)
+			}
+			if size != buff.Len() {
+				t.Fatalf("size %d, encoded %d bytes", size, buff.Len())
+			}
+
+			got, err := codec.ReadPacket(&buff)
+			if err != nil {
+				t.Fatalf("read : %v", err)
+			}
+			if buff.Len() != 0 {
+				t.Fatalf("%d bytes left unread", buff.Len())
+			}
+
+			if !reflect.DeepEqual(got, pkt) {
+				t.Fatalf("decoded %+v, want %+v", got, pkt)
+			}
+		})
+	}
+}
+
+func TestRoundTripV5(t *testing.T) {
+	roundTrip(t, V5, v5Packets())
+}
+
+func TestRoundTripV311(t *testing.T) {
+	roundTrip(t, V311, v311Packets())
+}
+
+func TestAuthV311(t *testing.T) {
+	codec := Codec{Version: V311}
+
+	if _, err := codec.Marshal(&Auth{}); !errors.Is(err, ErrVersion) {
+		t.Fatalf("encode : %v, want %v", err, ErrVersion)
+	}
+
+	b, err := Marshal(&Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codec.ReadPacket(bytes.NewReader(b)); !errors.Is(err, ErrVersion) {
+		t.Fatalf("read : %v, want %v", err, ErrVersion)
+	}
+}
@@ -0,0 +1,535 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+const (
+	PropPayloadFormatIndicator          byte = 0x01
+	PropMessageExpiryInterval           byte = 0x02
+	PropContentType                     byte = 0x03
+	PropResponseTopic                   byte = 0x08
+	PropCorrelationData                 byte = 0x09
+	PropSubscriptionIdentifier          byte = 0x0B
+	PropSessionExpiryInterval           byte = 0x11
+	PropAssignedClientIdentifier        byte = 0x12
+	PropServerKeepAlive                 byte = 0x13
+	PropAuthenticationMethod            byte = 0x15
+	PropAuthenticationData              byte = 0x16
+	PropRequestProblemInformation       byte = 0x17
+	PropWillDelayInterval               byte = 0x18
+	PropRequestResponseInformation      byte = 0x19
+	PropResponseInformation             byte = 0x1A
+	PropServerReference                 byte = 0x1C
+	PropReasonString                    byte = 0x1F
+	PropReceiveMaximum                  byte = 0x21
+	PropTopicAliasMaximum               byte = 0x22
+	PropTopicAlias                      byte = 0x23
+	PropMaximumQoS                      byte = 0x24
+	PropRetainAvailable                 byte = 0x25
+	PropUserProperty                    byte = 0x26
+	PropMaximumPacketSize               byte = 0x27
+	PropWildcardSubscriptionAvailable   byte = 0x28
+	PropSubscriptionIdentifierAvailable byte = 0x29
+	PropSharedSubscriptionAvailable     byte = 0x2A
+)
+
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+// Properties holds every MQTT 5 property. Nil pointers, empty strings and
+// nil slices are left out of the encoding.
+type Properties struct {
+	PayloadFormatIndicator          *byte
+	MessageExpiryInterval           *uint32
+	ContentType                     string
+	ResponseTopic                   string
+	CorrelationData                 []byte
+	SubscriptionIdentifiers         []int
+	SessionExpiryInterval           *uint32
+	AssignedClientIdentifier        string
+	ServerKeepAlive                 *uint16
+	AuthenticationMethod            string
+	AuthenticationData              []byte
+	RequestProblemInformation       *bool
+	WillDelayInterval               *uint32
+	RequestResponseInformation      *bool
+	ResponseInformation             string
+	ServerReference                 string
+	ReasonString                    string
+	ReceiveMaximum                  *uint16
+	TopicAliasMaximum               *uint16
+	TopicAlias                      *uint16
+	MaximumQoS                      *byte
+	RetainAvailable                 *bool
+	UserProperties                  []UserProperty
+	MaximumPacketSize               *uint32
+	WildcardSubscriptionAvailable   *bool
+	SubscriptionIdentifierAvailable *bool
+	SharedSubscriptionAvailable     *bool
+}
+
+// willProperties is the scope of the will properties of a CONNECT packet.
+const willProperties Type = 0x00
+
+var allowedProperties = map[byte][]Type{
+	PropPayloadFormatIndicator:          {TypePublish, willProperties},
+	PropMessageExpiryInterval:           {TypePublish, willProperties},
+	PropContentType:                     {TypePublish, willProperties},
+	PropResponseTopic:                   {TypePublish, willProperties},
+	PropCorrelationData:                 {TypePublish, willProperties},
+	PropSubscriptionIdentifier:          {TypePublish, TypeSubscribe},
+	PropSessionExpiryInterval:           {TypeConnect, TypeConnack, TypeDisconnect},
+	PropAssignedClientIdentifier:        {TypeConnack},
+	PropServerKeepAlive:                 {TypeConnack},
+	PropAuthenticationMethod:            {TypeConnect, TypeConnack, TypeAuth},
+	PropAuthenticationData:              {TypeConnect, TypeConnack, TypeAuth},
+	PropRequestProblemInformation:       {TypeConnect},
+	PropWillDelayInterval:               {willProperties},
+	PropRequestResponseInformation:      {TypeConnect},
+	PropResponseInformation:             {TypeConnack},
+	PropServerReference:                 {TypeConnack, TypeDisconnect},
+	PropReasonString:                    {TypeConnack, TypePuback, TypePubrec, TypePubrel, TypePubcomp, TypeSuback, TypeUnsuback, TypeDisconnect, TypeAuth},
+	PropReceiveMaximum:                  {TypeConnect, TypeConnack},
+	PropTopicAliasMaximum:               {TypeConnect, TypeConnack},
+	PropTopicAlias:                      {TypePublish},
+	PropMaximumQoS:                      {TypeConnack},
+	PropRetainAvailable:                 {TypeConnack},
+	PropUserProperty:                    {TypeConnect, TypeConnack, TypePublish, willProperties, TypePuback, TypePubrec, TypePubrel, TypePubcomp, TypeSubscribe, TypeSuback, TypeUnsubscribe, TypeUnsuback, TypeDisconnect, TypeAuth},
+	PropMaximumPacketSize:               {TypeConnect, TypeConnack},
+	PropWildcardSubscriptionAvailable:   {TypeConnack},
+	PropSubscriptionIdentifierAvailable: {TypeConnack},
+	PropSharedSubscriptionAvailable:     {TypeConnack},
+}
+
+func scopeName(t Type) string {
+	if t == willProperties {
+		return "will properties"
+	}
+	return t.String()
+}
+
+// ids returns the identifiers of the properties that are set.
+func (p *Properties) ids() []byte {
+	var ids []byte
+	set := func(id byte, ok bool) {
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+
+	set(PropPayloadFormatIndicator, p.PayloadFormatIndicator != nil)
+	set(PropMessageExpiryInterval, p.MessageExpiryInterval != nil)
+	set(PropContentType, p.ContentType != "")
+	set(PropResponseTopic, p.ResponseTopic != "")
+	set(PropCorrelationData, p.CorrelationData != nil)
+	set(PropSubscriptionIdentifier, len(p.SubscriptionIdentifiers) > 0)
+	set(PropSessionExpiryInterval, p.SessionExpiryInterval != nil)
+	set(PropAssignedClientIdentifier, p.AssignedClientIdentifier != "")
+	set(PropServerKeepAlive, p.ServerKeepAlive != nil)
+	set(PropAuthenticationMethod, p.AuthenticationMethod != "")
+	set(PropAuthenticationData, p.AuthenticationData != nil)
+	set(PropRequestProblemInformation, p.RequestProblemInformation != nil)
+	set(PropWillDelayInterval, p.WillDelayInterval != nil)
+	set(PropRequestResponseInformation, p.RequestResponseInformation != nil)
+	set(PropResponseInformation, p.ResponseInformation != "")
+	set(PropServerReference, p.ServerReference != "")
+	set(PropReasonString, p.ReasonString != "")
+	set(PropReceiveMaximum, p.ReceiveMaximum != nil)
+	set(PropTopicAliasMaximum, p.TopicAliasMaximum != nil)
+	set(PropTopicAlias, p.TopicAlias != nil)
+	set(PropMaximumQoS, p.MaximumQoS != nil)
+	set(PropRetainAvailable, p.RetainAvailable != nil)
+	set(PropUserProperty, len(p.UserProperties) > 0)
+	set(PropMaximumPacketSize, p.MaximumPacketSize != nil)
+	set(PropWildcardSubscriptionAvailable, p.WildcardSubscriptionAvailable != nil)
+	set(PropSubscriptionIdentifierAvailable, p.SubscriptionIdentifierAvailable != nil)
+	set(PropSharedSubscriptionAvailable, p.SharedSubscriptionAvailable != nil)
+
+	return ids
+}
+
+// Validate checks that every property set may be carried by a packet of type
+// t and holds a value allowed by the specification.
+func (p *Properties) Validate(t Type) error {
+	for _, id := range p.ids() {
+		if !slices.Contains(allowedProperties[id], t) {
+			return fmt.Errorf("%w : property 0x%02X not allowed in %s", ErrProtocolError, id, scopeName(t))
+		}
+	}
+
+	if p.PayloadFormatIndicator != nil && *p.PayloadFormatIndicator > 1 {
+		return fmt.Errorf("%w : payload format indicator %d", ErrProtocolError, *p.PayloadFormatIndicator)
+	}
+
+	if p.MaximumQoS != nil && *p.MaximumQoS > 1 {
+		return fmt.Errorf("%w : maximum QoS %d", ErrProtocolError, *p.MaximumQoS)
+	}
+
+	if p.ReceiveMaximum != nil && *p.ReceiveMaximum == 0 {
+		return fmt.Errorf("%w : receive maximum 0", ErrProtocolError)
+	}
+
+	if p.MaximumPacketSize != nil && *p.MaximumPacketSize == 0 {
+		return fmt.Errorf("%w : maximum packet size 0", ErrProtocolError)
+	}
+
+	if p.TopicAlias != nil && *p.TopicAlias == 0 {
+		return fmt.Errorf("%w : topic alias 0", ErrProtocolError)
+	}
+
+	if t == TypeSubscribe && len(p.SubscriptionIdentifiers) > 1 {
+		return fmt.Errorf("%w : more than one subscription identifier", ErrProtocolError)
+	}
+
+	for _, id := range p.SubscriptionIdentifiers {
+		if id < 1 || id > MaxRemainingLength {
+			return fmt.Errorf("%w : subscription identifier %d out of range", ErrProtocolError, id)
+		}
+	}
+
+	return nil
+}
+
+// Empty reports whether no property is set.
+func (p *Properties) Empty() bool {
+	return len(p.ids()) == 0
+}
+
+// noProperties checks that a packet encoded for a version without
+// properties does not carry any.
+func noProperties(p *Properties, v Version, t Type) error {
+	if v < V5 && !p.Empty() {
+		return fmt.Errorf("%w : %s properties", ErrVersion, scopeName(t))
+	}
+	return nil
+}
+
+func (p *Properties) encode(buff *bytes.Buffer, v Version, t Type) error {
+	if v < V5 {
+		return noProperties(p, v, t)
+	}
+
+	if err := p.Validate(t); err != nil {
+		return err
+	}
+
+	var props bytes.Buffer
+
+	if p.PayloadFormatIndicator != nil {
+		props.WriteByte(PropPayloadFormatIndicator)
+		props.WriteByte(*p.PayloadFormatIndicator)
+	}
+
+	if p.MessageExpiryInterval != nil {
+		props.WriteByte(PropMessageExpiryInterval)
+		writeUint32(&props, *p.MessageExpiryInterval)
+	}
+
+	if p.ContentType != "" {
+		props.WriteByte(PropContentType)
+		if err := writeString(&props, p.ContentType); err != nil {
+			return err
+		}
+	}
+
+	if p.ResponseTopic != "" {
+		props.WriteByte(PropResponseTopic)
+		if err := writeString(&props, p.ResponseTopic); err != nil {
+			return err
+		}
+	}
+
+	if p.CorrelationData != nil {
+		props.WriteByte(PropCorrelationData)
+		if err := writeBinary(&props, p.CorrelationData); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range p.SubscriptionIdentifiers {
+		props.WriteByte(PropSubscriptionIdentifier)
+		writeVarint(&props, id)
+	}
+
+	if p.SessionExpiryInterval != nil {
+		props.WriteByte(PropSessionExpiryInterval)
+		writeUint32(&props, *p.SessionExpiryInterval)
+	}
+
+	if p.AssignedClientIdentifier != "" {
+		props.WriteByte(PropAssignedClientIdentifier)
+		if err := writeString(&props, p.AssignedClientIdentifier); err != nil {
+			return err
+		}
+	}
+
+	if p.ServerKeepAlive != nil {
+		props.WriteByte(PropServerKeepAlive)
+		writeUint16(&props, *p.ServerKeepAlive)
+	}
+
+	if p.AuthenticationMethod != "" {
+		props.WriteByte(PropAuthenticationMethod)
+		if err := writeString(&props, p.AuthenticationMethod); err != nil {
+			return err
+		}
+	}
+
+	if p.AuthenticationData != nil {
+		props.WriteByte(PropAuthenticationData)
+		if err := writeBinary(&props, p.AuthenticationData); err != nil {
+			return err
+		}
+	}
+
+	if p.RequestProblemInformation != nil {
+		props.WriteByte(PropRequestProblemInformation)
+		props.WriteByte(boolByte(*p.RequestProblemInformation))
+	}
+
+	if p.WillDelayInterval != nil {
+		props.WriteByte(PropWillDelayInterval)
+		writeUint32(&props, *p.WillDelayInterval)
+	}
+
+	if p.RequestResponseInformation != nil {
+		props.WriteByte(PropRequestResponseInformation)
+		props.WriteByte(boolByte(*p.RequestResponseInformation))
+	}
+
+	if p.ResponseInformation != "" {
+		props.WriteByte(PropResponseInformation)
+		if err := writeString(&props, p.ResponseInformation); err != nil {
+			return err
+		}
+	}
+
+	if p.ServerReference != "" {
+		props.WriteByte(PropServerReference)
+		if err := writeString(&props, p.ServerReference); err != nil {
+			return err
+		}
+	}
+
+	if p.ReasonString != "" {
+		props.WriteByte(PropReasonString)
+		if err := writeString(&props, p.ReasonString); err != nil {
+			return err
+		}
+	}
+
+	if p.ReceiveMaximum != nil {
+		props.WriteByte(PropReceiveMaximum)
+		writeUint16(&props, *p.ReceiveMaximum)
+	}
+
+	if p.TopicAliasMaximum != nil {
+		props.WriteByte(PropTopicAliasMaximum)
+		writeUint16(&props, *p.TopicAliasMaximum)
+	}
+
+	if p.TopicAlias != nil {
+		props.WriteByte(PropTopicAlias)
+		writeUint16(&props, *p.TopicAlias)
+	}
+
+	if p.MaximumQoS != nil {
+		props.WriteByte(PropMaximumQoS)
+		props.WriteByte(*p.MaximumQoS)
+	}
+
+	if p.RetainAvailable != nil {
+		props.WriteByte(PropRetainAvailable)
+		props.WriteByte(boolByte(*p.RetainAvailable))
+	}
+
+	for _, up := range p.UserProperties {
+		props.WriteByte(PropUserProperty)
+		if err := writeString(&props, up.Key); err != nil {
+			return err
+		}
+		if err := writeString(&props, up.Value); err != nil {
+			return err
+		}
+	}
+
+	if p.MaximumPacketSize != nil {
+		props.WriteByte(PropMaximumPacketSize)
+		writeUint32(&props, *p.MaximumPacketSize)
+	}
+
+	if p.WildcardSubscriptionAvailable != nil {
+		props.WriteByte(PropWildcardSubscriptionAvailable)
+		props.WriteByte(boolByte(*p.WildcardSubscriptionAvailable))
+	}
+
+	if p.SubscriptionIdentifierAvailable != nil {
+		props.WriteByte(PropSubscriptionIdentifierAvailable)
+		props.WriteByte(boolByte(*p.SubscriptionIdentifierAvailable))
+	}
+
+	if p.SharedSubscriptionAvailable != nil {
+		props.WriteByte(PropSharedSubscriptionAvailable)
+		props.WriteByte(boolByte(*p.SharedSubscriptionAvailable))
+	}
+
+	writeVarint(buff, props.Len())
+	buff.Write(props.Bytes())
+	return nil
+}
+
+func decodeProperties(d *decoder, t Type) (Properties, error) {
+	var p Properties
+
+	// MQTT 3.1.1 packets carry no properties
+	if d.version < V5 {
+		return p, nil
+	}
+
+	length, err := d.varint()
+	if err != nil {
+		return p, err
+	}
+
+	raw, err := d.bytes(length)
+	if err != nil {
+		return p, err
+	}
+
+	pd := newDecoder(raw)
+	seen := make(map[byte]bool)
+
+	for pd.len() > 0 {
+		id, err := pd.varint()
+		if err != nil {
+			return p, err
+		}
+		key := byte(id)
+
+		if seen[key] && key != PropUserProperty && key != PropSubscriptionIdentifier {
+			return p, fmt.Errorf("%w : property 0x%02X included more than once", ErrProtocolError, key)
+		}
+		seen[key] = true
+
+		if err := p.decodeOne(key, pd); err != nil {
+			return p, err
+		}
+	}
+
+	return p, p.Validate(t)
+}
+
+func (p *Properties) decodeOne(key byte, d *decoder) error {
+	var err error
+
+	switch key {
+	case PropPayloadFormatIndicator:
+		p.PayloadFormatIndicator, err = decodeByte(d)
+	case PropMessageExpiryInterval:
+		p.MessageExpiryInterval, err = decodeUint32(d)
+	case PropContentType:
+		p.ContentType, err = d.string()
+	case PropResponseTopic:
+		p.ResponseTopic, err = d.string()
+	case PropCorrelationData:
+		p.CorrelationData, err = d.binary()
+	case PropSubscriptionIdentifier:
+		var id int
+		if id, err = d.varint(); err == nil {
+			p.SubscriptionIdentifiers = append(p.SubscriptionIdentifiers, id)
+		}
+	case PropSessionExpiryInterval:
+		p.SessionExpiryInterval, err = decodeUint32(d)
+	case PropAssignedClientIdentifier:
+		p.AssignedClientIdentifier, err = d.string()
+	case PropServerKeepAlive:
+		p.ServerKeepAlive, err = decodeUint16(d)
+	case PropAuthenticationMethod:
+		p.AuthenticationMethod, err = d.string()
+	case PropAuthenticationData:
+		p.AuthenticationData, err = d.binary()
+	case PropRequestProblemInformation:
+		p.RequestProblemInformation, err = decodeBool(d)
+	case PropWillDelayInterval:
+		p.WillDelayInterval, err = decodeUint32(d)
+	case PropRequestResponseInformation:
+		p.RequestResponseInformation, err = decodeBool(d)
+	case PropResponseInformation:
+		p.ResponseInformation, err = d.string()
+	case PropServerReference:
+		p.ServerReference, err = d.string()
+	case PropReasonString:
+		p.ReasonString, err = d.string()
+	case PropReceiveMaximum:
+		p.ReceiveMaximum, err = decodeUint16(d)
+	case PropTopicAliasMaximum:
+		p.TopicAliasMaximum, err = decodeUint16(d)
+	case PropTopicAlias:
+		p.TopicAlias, err = decodeUint16(d)
+	case PropMaximumQoS:
+		p.MaximumQoS, err = decodeByte(d)
+	case PropRetainAvailable:
+		p.RetainAvailable, err = decodeBool(d)
+	case PropUserProperty:
+		var up UserProperty
+		if up.Key, err = d.string(); err != nil {
+			return err
+		}
+		if up.Value, err = d.string(); err == nil {
+			p.UserProperties = append(p.UserProperties, up)
+		}
+	case PropMaximumPacketSize:
+		p.MaximumPacketSize, err = decodeUint32(d)
+	case PropWildcardSubscriptionAvailable:
+		p.WildcardSubscriptionAvailable, err = decodeBool(d)
+	case PropSubscriptionIdentifierAvailable:
+		p.SubscriptionIdentifierAvailable, err = decodeBool(d)
+	case PropSharedSubscriptionAvailable:
+		p.SharedSubscriptionAvailable, err = decodeBool(d)
+	default:
+		return fmt.Errorf("%w : unknown property 0x%02X", ErrMalformedPacket, key)
+	}
+
+	return err
+}
+
+func decodeByte(d *decoder) (*byte, error) {
+	v, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func decodeBool(d *decoder) (*bool, error) {
+	v, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	if v > 1 {
+		return nil, fmt.Errorf("%w : boolean property value %d", ErrProtocolError, v)
+	}
+	b := v == 1
+	return &b, nil
+}
+
+func decodeUint16(d *decoder) (*uint16, error) {
+	v, err := d.uint16()
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func decodeUint32(d *decoder) (*uint32, error) {
+	v, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
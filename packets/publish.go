@@ -0,0 +1,89 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+type Publish struct {
+	Dup        bool
+	QoS        byte
+	Retain     bool
+	Topic      string
+	PacketID   uint16
+	Properties Properties
+	Payload    []byte
+}
+
+func (p *Publish) Type() Type { return TypePublish }
+
+func (p *Publish) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Publish) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Publish) encodeBody(v Version) (byte, []byte, error) {
+	if p.QoS > 2 {
+		return 0, nil, fmt.Errorf("%w : QoS %d", ErrProtocolError, p.QoS)
+	}
+
+	var flags byte
+	if p.Dup {
+		flags |= 0x08
+	}
+	flags |= p.QoS << 1
+	if p.Retain {
+		flags |= 0x01
+	}
+
+	var buff bytes.Buffer
+	if err := writeString(&buff, p.Topic); err != nil {
+		return 0, nil, err
+	}
+
+	if p.QoS > 0 {
+		if p.PacketID == 0 {
+			return 0, nil, fmt.Errorf("%w : QoS %d publish without packet identifier", ErrProtocolError, p.QoS)
+		}
+		writeUint16(&buff, p.PacketID)
+	}
+
+	if err := p.Properties.encode(&buff, v, TypePublish); err != nil {
+		return 0, nil, err
+	}
+	buff.Write(p.Payload)
+
+	return flags, buff.Bytes(), nil
+}
+
+func (p *Publish) decodeBody(flags byte, d *decoder) error {
+	p.Dup = flags&0x08 != 0
+	p.QoS = (flags >> 1) & 0x03
+	p.Retain = flags&0x01 != 0
+
+	if p.QoS > 2 {
+		return fmt.Errorf("%w : QoS %d", ErrMalformedPacket, p.QoS)
+	}
+
+	var err error
+	if p.Topic, err = d.string(); err != nil {
+		return err
+	}
+
+	p.PacketID = 0
+	if p.QoS > 0 {
+		if p.PacketID, err = d.uint16(); err != nil {
+			return err
+		}
+		if p.PacketID == 0 {
+			return fmt.Errorf("%w : packet identifier 0", ErrMalformedPacket)
+		}
+	}
+
+	if p.Properties, err = decodeProperties(d, TypePublish); err != nil {
+		return err
+	}
+
+	p.Payload = d.rest()
+	return nil
+}
@@ -0,0 +1,266 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+type Subscription struct {
+	Topic             string
+	QoS               byte
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    byte
+}
+
+func (s Subscription) options(v Version) (byte, error) {
+	if s.QoS > 2 {
+		return 0, fmt.Errorf("%w : subscription QoS %d", ErrProtocolError, s.QoS)
+	}
+	if s.RetainHandling > 2 {
+		return 0, fmt.Errorf("%w : retain handling %d", ErrProtocolError, s.RetainHandling)
+	}
+
+	if v < V5 && (s.NoLocal || s.RetainAsPublished || s.RetainHandling != 0) {
+		return 0, fmt.Errorf("%w : subscription options other than QoS", ErrVersion)
+	}
+
+	opts := s.QoS | s.RetainHandling<<4
+	if s.NoLocal {
+		opts |= 0x04
+	}
+	if s.RetainAsPublished {
+		opts |= 0x08
+	}
+	return opts, nil
+}
+
+type Subscribe struct {
+	PacketID      uint16
+	Properties    Properties
+	Subscriptions []Subscription
+}
+
+func (p *Subscribe) Type() Type { return TypeSubscribe }
+
+func (p *Subscribe) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Subscribe) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Subscribe) encodeBody(v Version) (byte, []byte, error) {
+	if len(p.Subscriptions) == 0 {
+		return 0, nil, fmt.Errorf("%w : subscribe without topic filter", ErrProtocolError)
+	}
+
+	var buff bytes.Buffer
+	writeUint16(&buff, p.PacketID)
+	if err := p.Properties.encode(&buff, v, TypeSubscribe); err != nil {
+		return 0, nil, err
+	}
+
+	for _, s := range p.Subscriptions {
+		opts, err := s.options(v)
+		if err != nil {
+			return 0, nil, err
+		}
+		if err := writeString(&buff, s.Topic); err != nil {
+			return 0, nil, err
+		}
+		buff.WriteByte(opts)
+	}
+
+	return 0x02, buff.Bytes(), nil
+}
+
+func (p *Subscribe) decodeBody(flags byte, d *decoder) error {
+	if err := expectFlags(TypeSubscribe, flags, 0x02); err != nil {
+		return err
+	}
+
+	var err error
+	if p.PacketID, err = d.uint16(); err != nil {
+		return err
+	}
+	if p.Properties, err = decodeProperties(d, TypeSubscribe); err != nil {
+		return err
+	}
+
+	p.Subscriptions = nil
+	for d.len() > 0 {
+		var s Subscription
+		if s.Topic, err = d.string(); err != nil {
+			return err
+		}
+
+		opts, err := d.byte()
+		if err != nil {
+			return err
+		}
+		if opts&0xc0 != 0 || (d.version < V5 && opts&0xfc != 0) {
+			return fmt.Errorf("%w : reserved subscription option bits set", ErrMalformedPacket)
+		}
+
+		s.QoS = opts & 0x03
+		s.NoLocal = opts&0x04 != 0
+		s.RetainAsPublished = opts&0x08 != 0
+		s.RetainHandling = (opts >> 4) & 0x03
+		if s.QoS > 2 || s.RetainHandling > 2 {
+			return fmt.Errorf("%w : invalid subscription options 0x%02X", ErrMalformedPacket, opts)
+		}
+
+		p.Subscriptions = append(p.Subscriptions, s)
+	}
+
+	if len(p.Subscriptions) == 0 {
+		return fmt.Errorf("%w : subscribe without topic filter", ErrProtocolError)
+	}
+	return nil
+}
+
+type Suback struct {
+	PacketID    uint16
+	Properties  Properties
+	ReasonCodes []byte
+}
+
+func (p *Suback) Type() Type { return TypeSuback }
+
+func (p *Suback) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Suback) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Suback) encodeBody(v Version) (byte, []byte, error) {
+	b, err := encodeCodes(v, TypeSuback, p.PacketID, &p.Properties, p.ReasonCodes)
+	return 0, b, err
+}
+
+func (p *Suback) decodeBody(flags byte, d *decoder) error {
+	if err := expectFlags(TypeSuback, flags, 0); err != nil {
+		return err
+	}
+
+	var err error
+	p.PacketID, p.Properties, p.ReasonCodes, err = decodeCodes(TypeSuback, d)
+	return err
+}
+
+type Unsubscribe struct {
+	PacketID   uint16
+	Properties Properties
+	Topics     []string
+}
+
+func (p *Unsubscribe) Type() Type { return TypeUnsubscribe }
+
+func (p *Unsubscribe) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Unsubscribe) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Unsubscribe) encodeBody(v Version) (byte, []byte, error) {
+	if len(p.Topics) == 0 {
+		return 0, nil, fmt.Errorf("%w : unsubscribe without topic filter", ErrProtocolError)
+	}
+
+	var buff bytes.Buffer
+	writeUint16(&buff, p.PacketID)
+	if err := p.Properties.encode(&buff, v, TypeUnsubscribe); err != nil {
+		return 0, nil, err
+	}
+
+	for _, topic := range p.Topics {
+		if err := writeString(&buff, topic); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return 0x02, buff.Bytes(), nil
+}
+
+func (p *Unsubscribe) decodeBody(flags byte, d *decoder) error {
+	if err := expectFlags(TypeUnsubscribe, flags, 0x02); err != nil {
+		return err
+	}
+
+	var err error
+	if p.PacketID, err = d.uint16(); err != nil {
+		return err
+	}
+	if p.Properties, err = decodeProperties(d, TypeUnsubscribe); err != nil {
+		return err
+	}
+
+	p.Topics = nil
+	for d.len() > 0 {
+		topic, err := d.string()
+		if err != nil {
+			return err
+		}
+		p.Topics = append(p.Topics, topic)
+	}
+
+	if len(p.Topics) == 0 {
+		return fmt.Errorf("%w : unsubscribe without topic filter", ErrProtocolError)
+	}
+	return nil
+}
+
+type Unsuback struct {
+	PacketID    uint16
+	Properties  Properties
+	ReasonCodes []byte
+}
+
+func (p *Unsuback) Type() Type { return TypeUnsuback }
+
+func (p *Unsuback) Encode(w io.Writer) error { return encode(w, V5, p) }
+
+func (p *Unsuback) Decode(r io.Reader) error { return decode(r, p) }
+
+func (p *Unsuback) encodeBody(v Version) (byte, []byte, error) {
+	// MQTT 3.1.1 UNSUBACK has no payload
+	if v < V5 && len(p.ReasonCodes) > 0 {
+		return 0, nil, fmt.Errorf("%w : %s reason codes", ErrVersion, TypeUnsuback)
+	}
+
+	b, err := encodeCodes(v, TypeUnsuback, p.PacketID, &p.Properties, p.ReasonCodes)
+	return 0, b, err
+}
+
+func (p *Unsuback) decodeBody(flags byte, d *decoder) error {
+	if err := expectFlags(TypeUnsuback, flags, 0); err != nil {
+		return err
+	}
+
+	var err error
+	p.PacketID, p.Properties, p.ReasonCodes, err = decodeCodes(TypeUnsuback, d)
+	if err == nil && d.version < V5 && len(p.ReasonCodes) > 0 {
+		return fmt.Errorf("%w : %s with payload", ErrMalformedPacket, TypeUnsuback)
+	}
+	return err
+}
+
+// encodeCodes writes the layout shared by SUBACK and UNSUBACK.
+func encodeCodes(v Version, t Type, id uint16, props *Properties, codes []byte) ([]byte, error) {
+	var buff bytes.Buffer
+	writeUint16(&buff, id)
+	if err := props.encode(&buff, v, t); err != nil {
+		return nil, err
+	}
+	buff.Write(codes)
+	return buff.Bytes(), nil
+}
+
+func decodeCodes(t Type, d *decoder) (uint16, Properties, []byte, error) {
+	id, err := d.uint16()
+	if err != nil {
+		return 0, Properties{}, nil, err
+	}
+
+	props, err := decodeProperties(d, t)
+	if err != nil {
+		return 0, Properties{}, nil, err
+	}
+
+	return id, props, d.rest(), nil
+}
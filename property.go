@@ -1,17 +1,8 @@
 package portergosdk
 
-import "fmt"
+import "github.com/macdaih/porter_go_sdk/packets"
 
-type property struct {
-	key   byte
-	value interface{}
-	size  int
-}
-
-type keyPair struct {
-	key   string
-	value string
-}
+type UserProperty = packets.UserProperty
 
 const (
 	MQTT_PROP_PAYLOAD_FORMAT_INDICATOR     = 1
@@ -43,114 +34,15 @@ const (
 	MQTT_PROP_SHARED_SUB_AVAILABLE         = 42
 )
 
-func readProperty(pkt *packet) (property, error) {
-	pkey, err := pkt.readByte()
-	if err != nil {
-		return property{}, err
-	}
-
-	switch pkey {
-	case
-		MQTT_PROP_SESSION_EXPIRY_INTERVAL,
-		MQTT_PROP_MAXIMUM_PACKET_SIZE,
-		MQTT_PROP_WILL_DELAY_INTERVAL,
-		MQTT_PROP_MESSAGE_EXPIRY_INTERVAL:
-		value, err := pkt.readUint32()
-		if err != nil {
-			return property{}, err
-		}
-		return property{
-			key:   pkey,
-			value: value,
-			size:  4,
-		}, nil
-	case
-		MQTT_PROP_RECEIVE_MAXIMUM,
-		MQTT_PROP_TOPIC_ALIAS_MAXIMUM,
-		MQTT_PROP_TOPIC_ALIAS:
-		value, err := pkt.readUint16()
-		if err != nil {
-			return property{}, err
-		}
-
-		return property{
-			key:   pkey,
-			value: value,
-			size:  2,
-		}, nil
-	case
-		MQTT_PROP_REQUEST_PROBLEM_INFORMATION,
-		MQTT_PROP_REQUEST_RESPONSE_INFORMATION,
-		MQTT_PROP_PAYLOAD_FORMAT_INDICATOR,
-		MQTT_PROP_MAXIMUM_QOS,
-		MQTT_PROP_RETAIN_AVAILABLE,
-		MQTT_PROP_WILDCARD_SUB_AVAILABLE,
-		MQTT_PROP_SUBSCRIPTION_ID_AVAILABLE,
-		MQTT_PROP_SHARED_SUB_AVAILABLE:
-		value, err := pkt.readByte()
-		if err != nil {
-			return property{}, err
-		}
-
-		return property{
-			key:   pkey,
-			value: value,
-			size:  1,
-		}, nil
-	case MQTT_PROP_SUBSCRIPTION_ID:
-		value, err := pkt.readVarint()
-		if err != nil {
-			return property{}, err
-		}
-
-		bsize := evalBytes(value)
-
-		return property{
-			key:   pkey,
-			value: value,
-			size:  bsize,
-		}, nil
-	case MQTT_PROP_USER_PROPERTY:
-		key, err := pkt.readString()
-		if err != nil {
-			return property{}, err
-		}
-
-		value, err := pkt.readString()
-		if err != nil {
-			return property{}, err
-		}
-
-		return property{
-			key:   pkey,
-			value: keyPair{key: key, value: value},
-			size:  (len(key) + 2) + (len(value) + 2),
-		}, nil
-	case
-		MQTT_PROP_AUTHENTICATION_METHOD,
-		MQTT_PROP_AUTHENTICATION_DATA,
-		MQTT_PROP_CONTENT_TYPE,
-		MQTT_PROP_RESPONSE_TOPIC,
-		MQTT_PROP_CORRELATION_DATA,
-		MQTT_PROP_SERVER_REFERENCE,
-		MQTT_PROP_REASON_STRING:
-		value, err := pkt.readString()
-		if err != nil {
-			return property{}, err
-		}
-
-		return property{
-			key:   pkey,
-			value: value,
-			size:  len(value) + 2,
-		}, nil
-	default:
-		return property{}, fmt.Errorf("unknown property %x", pkey)
-	}
+func ptr[T any](v T) *T {
+	return &v
 }
 
-func newProperty() property {
-	return property{}
+// deref returns the value of an optional property, or its zero value.
+func deref[T any](v *T) T {
+	var zero T
+	if v == nil {
+		return zero
+	}
+	return *v
 }
-
-// func (p property)
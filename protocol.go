@@ -13,7 +13,12 @@ const (
 	CodePublish    CodeString = "publish"
 	CodeSubAck     CodeString = "suback"
 	CodePubAck     CodeString = "puback"
+	CodePubRec     CodeString = "pubrec"
+	CodePubRel     CodeString = "pubrel"
+	CodePubComp    CodeString = "pubcomp"
+	CodeUnsubAck   CodeString = "unsuback"
 	CodeDisconnect CodeString = "disconnect"
+	CodeAuth       CodeString = "auth"
 	CodeUnknown    CodeString = "unknown"
 )
 
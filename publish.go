@@ -1,7 +1,11 @@
 package portergosdk
 
 import (
-	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/macdaih/porter_go_sdk/packets"
 )
 
 type ContentType string
@@ -11,117 +15,127 @@ const (
 	Text ContentType = "text/plain"
 )
 
+var ErrInvalidPayloadFormat = errors.New("payload is not valid UTF-8")
+
 type AppMessage struct {
 	MessageQoS  QoS
 	TopicName   string
 	Format      bool
 	Content     ContentType
 	Correlation string
-	SubID       string
-	Payload     []byte
+	// ResponseTopic is where a request expects its response.
+	ResponseTopic string
+	SubID         string
+	// Expiry is the message expiry interval in seconds, 0 for none.
+	Expiry         uint32
+	UserProperties []UserProperty
+	Payload        []byte
 }
 
-func buildPublish(appMsg AppMessage) ([]byte, error) {
-	var header bytes.Buffer
-	header.WriteByte(PublishCMD ^ (0 << 1))
+func buildPublish(version ProtocolVersion, appMsg AppMessage, pktID uint16, dup bool, alias topicAlias) ([]byte, error) {
+	pkt := packets.Publish{
+		Dup:     dup,
+		QoS:     appMsg.MessageQoS.level(),
+		Topic:   appMsg.TopicName,
+		Payload: appMsg.Payload,
+	}
 
-	props := make([]Prop, 0, 7)
-	propLen := 0
+	if pkt.QoS > 0 {
+		pkt.PacketID = pktID
+	}
 
-	if appMsg.Format {
-		prop, err := NewProperty(Byte, 0x01, byte(0x01))
-		if err != nil {
-			return nil, err
-		}
-		props = append(props, prop)
-		propLen += len(prop.value) + 1
+	// the payload format and content type are MQTT 5 properties
+	if version < V5 {
+		return packets.Codec{Version: version}.Marshal(&pkt)
 	}
 
-	if appMsg.Content != "" {
-		prop, err := NewProperty(EncString, 0x03, string(appMsg.Content))
-		if err != nil {
-			return nil, err
-		}
-		props = append(props, prop)
-		propLen += len(prop.value) + 1
+	// the indicator only describes the payload, which is sent untouched
+	if appMsg.Format {
+		pkt.Properties.PayloadFormatIndicator = ptr(byte(0x01))
 	}
 
-	var msg bytes.Buffer
+	pkt.Properties.ContentType = string(appMsg.Content)
 
-	if err := writeUTFString(&msg, appMsg.TopicName); err != nil {
-		return nil, err
+	if appMsg.Expiry > 0 {
+		pkt.Properties.MessageExpiryInterval = ptr(appMsg.Expiry)
 	}
 
-	if appMsg.MessageQoS > 0 {
-		if err := writeUint16(&msg, 0); err != nil {
-			return nil, err
-		}
+	pkt.Properties.ResponseTopic = appMsg.ResponseTopic
+	if appMsg.Correlation != "" {
+		pkt.Properties.CorrelationData = []byte(appMsg.Correlation)
 	}
+	pkt.Properties.UserProperties = appMsg.UserProperties
 
-	if err := encodeVarInt(&msg, propLen); err != nil {
-		return nil, err
-	}
-	for _, p := range props {
-		msg.WriteByte(p.key)
-		if _, err := msg.Write(p.value); err != nil {
-			return nil, err
-		}
+	if alias.id > 0 {
+		pkt.Properties.TopicAlias = ptr(alias.id)
 	}
 
-	var (
-		payload bytes.Buffer
-		np      int
-		perr    error
-	)
-	if appMsg.Format {
-		if err := writeUTFString(&payload, string(appMsg.Payload)); err != nil {
-			return nil, err
-		}
-
-		np, perr = msg.Write(payload.Bytes())
-		if perr != nil {
-			return nil, perr
-		}
-	} else {
-		np, perr = msg.Write(appMsg.Payload)
-		if perr != nil {
-			return nil, perr
-		}
+	if alias.known {
+		pkt.Topic = ""
 	}
 
-	remLen := (len(appMsg.TopicName) + 2) + evalBytes(uint32(propLen)) + propLen + np
-	if err := encodeVarInt(&header, remLen); err != nil {
-		return nil, err
+	return packets.Marshal(&pkt)
+}
 
+// checkPayloadFormat refuses a payload announced as UTF-8 that is not.
+func checkPayloadFormat(msg AppMessage) error {
+	if msg.Format && !utf8.Valid(msg.Payload) {
+		return fmt.Errorf("%w : %s", ErrInvalidPayloadFormat, msg.TopicName)
 	}
+	return nil
+}
 
-	if _, err := header.Write(msg.Bytes()); err != nil {
-		return nil, err
+// refusePublish answers an inbound message that cannot be accepted with code,
+// QoS 0 messages being dropped.
+func refusePublish(c *connection, qos QoS, id uint16, code ReasonCode) error {
+	switch qos.level() {
+	case 1:
+		return c.sendAck(pubackcmd, id, code)
+	case 2:
+		return c.sendAck(pubreccmd, id, code)
+	default:
+		return nil
 	}
-	return header.Bytes(), nil
 }
 
-func readPublish(pkt *packet) (AppMessage, error) {
-	var msg AppMessage
-
-	// Read topic
-	topic, err := pkt.readString()
-	if err != nil {
-		return msg, err
+func readPublish(pkt *packets.Publish) (AppMessage, uint16) {
+	props := pkt.Properties
+
+	msg := AppMessage{
+		MessageQoS:     qosFromLevel(pkt.QoS),
+		TopicName:      pkt.Topic,
+		Format:         deref(props.PayloadFormatIndicator) == 0x01,
+		Content:        ContentType(props.ContentType),
+		Correlation:    string(props.CorrelationData),
+		ResponseTopic:  props.ResponseTopic,
+		Expiry:         deref(props.MessageExpiryInterval),
+		UserProperties: props.UserProperties,
+		Payload:        pkt.Payload,
 	}
-	msg.TopicName = topic
 
-	// qos
-	if (pkt.flags & 0x06) > 0 {
-		if _, err := pkt.readUint16(); err != nil {
-			return msg, err
-		}
-	}
+	return msg, pkt.PacketID
+}
 
-	if _, err := pkt.readProperties(8); err != nil {
-		return msg, err
+// buildAck encodes PUBACK, PUBREC, PUBREL and PUBCOMP packets.
+func buildAck(version ProtocolVersion, cmd packetType, pktID uint16, code ReasonCode) ([]byte, error) {
+	ack := packets.Ack{PacketID: pktID}
+	// MQTT 3.1.1 acknowledgements carry no reason code
+	if version == V5 {
+		ack.ReasonCode = byte(code)
 	}
 
-	msg.Payload = pkt.buffer.Bytes()
-	return msg, nil
+	codec := packets.Codec{Version: version}
+
+	switch cmd {
+	case pubackcmd:
+		return codec.Marshal(&packets.Puback{Ack: ack})
+	case pubreccmd:
+		return codec.Marshal(&packets.Pubrec{Ack: ack})
+	case pubrelcmd:
+		return codec.Marshal(&packets.Pubrel{Ack: ack})
+	case pubcompcmd:
+		return codec.Marshal(&packets.Pubcomp{Ack: ack})
+	default:
+		return nil, fmt.Errorf("%w : 0x%02X is not an acknowledgement", ErrInvalidCommand, byte(cmd))
+	}
 }
@@ -0,0 +1,565 @@
+package portergosdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrQueueFull = errors.New("offline queue is full")
+
+// errDrainStopped reports that the queue stopped draining with messages left.
+var errDrainStopped = errors.New("offline queue stopped draining")
+
+// OverflowPolicy decides what happens to a message published while the
+// offline queue is full.
+type OverflowPolicy uint8
+
+const (
+	// DropOldest discards the oldest queued messages to make room.
+	DropOldest OverflowPolicy = iota
+	// DropNewest refuses the message with ErrQueueFull.
+	DropNewest
+	// Block waits for room until the Publish context ends.
+	Block
+)
+
+// QueuedMessage is a message published while the client was offline.
+type QueuedMessage struct {
+	Message AppMessage
+	Queued  time.Time
+}
+
+// remaining returns the message with the expiry interval left since it was
+// queued, false once it expired.
+func (m QueuedMessage) remaining(now time.Time) (AppMessage, bool) {
+	return remainingExpiry(m.Message, m.Queued, now)
+}
+
+func messageSize(msg AppMessage) int {
+	return len(msg.TopicName) + len(msg.Payload)
+}
+
+// QueueStore keeps the offline messages in publish order. Calls are
+// serialized by the client.
+type QueueStore interface {
+	Push(msg QueuedMessage) error
+	Front() (QueuedMessage, bool, error)
+	Pop() error
+	Len() int
+	// Bytes is the size of the queued topics and payloads.
+	Bytes() int
+}
+
+type OfflineQueueOptions struct {
+	// MaxMessages and MaxBytes bound the queue, 0 meaning no limit.
+	MaxMessages int
+	MaxBytes    int
+	Overflow    OverflowPolicy
+	// Store defaults to an in-memory queue.
+	Store QueueStore
+}
+
+// WithOfflineQueue queues the messages published while the server cannot be
+// reached instead of failing, they are sent in order once the client is
+// connected again. Publish then returns once the message is queued. Queued
+// messages whose expiry interval elapsed are dropped and the others are sent
+// with the interval left. A message larger than the Maximum Packet Size of
+// the last connection is refused, and a queued message the server refuses
+// once sent is logged.
+func WithOfflineQueue(opts OfflineQueueOptions) Option {
+	return func(c *PorterClient) {
+		if opts.Store == nil {
+			opts.Store = NewMemoryQueue()
+		}
+		c.queue = &offlineQueue{
+			opts:  opts,
+			store: opts.Store,
+			space: make(chan struct{}),
+		}
+	}
+}
+
+type MemoryQueue struct {
+	msgs  []QueuedMessage
+	bytes int
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{}
+}
+
+func (q *MemoryQueue) Push(msg QueuedMessage) error {
+	q.msgs = append(q.msgs, msg)
+	q.bytes += messageSize(msg.Message)
+	return nil
+}
+
+func (q *MemoryQueue) Front() (QueuedMessage, bool, error) {
+	if len(q.msgs) == 0 {
+		return QueuedMessage{}, false, nil
+	}
+	return q.msgs[0], true, nil
+}
+
+func (q *MemoryQueue) Pop() error {
+	if len(q.msgs) == 0 {
+		return nil
+	}
+
+	q.bytes -= messageSize(q.msgs[0].Message)
+	q.msgs[0] = QueuedMessage{}
+	q.msgs = q.msgs[1:]
+	return nil
+}
+
+func (q *MemoryQueue) Len() int {
+	return len(q.msgs)
+}
+
+func (q *MemoryQueue) Bytes() int {
+	return q.bytes
+}
+
+// FileQueue keeps one file per message in a directory, named after a
+// sequence number so the queue survives a restart in order.
+type FileQueue struct {
+	dir   string
+	seqs  []uint64
+	sizes []int
+	next  uint64
+	bytes int
+}
+
+func NewFileQueue(dir string) (*FileQueue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create offline queue : %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read offline queue : %w", err)
+	}
+
+	q := &FileQueue{dir: dir}
+	for _, e := range entries {
+		seq, ok := queueFileSeq(e.Name())
+		if ok {
+			q.seqs = append(q.seqs, seq)
+		}
+	}
+	sort.Slice(q.seqs, func(i, j int) bool { return q.seqs[i] < q.seqs[j] })
+
+	for _, seq := range q.seqs {
+		msg, err := q.read(seq)
+		if err != nil {
+			return nil, err
+		}
+
+		size := messageSize(msg.Message)
+		q.sizes = append(q.sizes, size)
+		q.bytes += size
+		q.next = seq + 1
+	}
+
+	return q, nil
+}
+
+func (q *FileQueue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.json", seq))
+}
+
+func (q *FileQueue) read(seq uint64) (QueuedMessage, error) {
+	var msg QueuedMessage
+
+	b, err := os.ReadFile(q.path(seq))
+	if err != nil {
+		return msg, err
+	}
+
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return msg, fmt.Errorf("failed to decode %s : %w", filepath.Base(q.path(seq)), err)
+	}
+
+	return msg, nil
+}
+
+func (q *FileQueue) Push(msg QueuedMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(q.path(q.next), b); err != nil {
+		return err
+	}
+
+	size := messageSize(msg.Message)
+	q.seqs = append(q.seqs, q.next)
+	q.sizes = append(q.sizes, size)
+	q.bytes += size
+	q.next++
+	return nil
+}
+
+func (q *FileQueue) Front() (QueuedMessage, bool, error) {
+	if len(q.seqs) == 0 {
+		return QueuedMessage{}, false, nil
+	}
+
+	msg, err := q.read(q.seqs[0])
+	if err != nil {
+		return msg, false, err
+	}
+	return msg, true, nil
+}
+
+func (q *FileQueue) Pop() error {
+	if len(q.seqs) == 0 {
+		return nil
+	}
+
+	if err := removeFile(q.path(q.seqs[0])); err != nil {
+		return err
+	}
+
+	q.bytes -= q.sizes[0]
+	q.seqs = q.seqs[1:]
+	q.sizes = q.sizes[1:]
+	return nil
+}
+
+func (q *FileQueue) Len() int {
+	return len(q.seqs)
+}
+
+func (q *FileQueue) Bytes() int {
+	return q.bytes
+}
+
+// queueFileSeq parses the sequence number of a queue file, temporary files
+// left by a crash being skipped.
+func queueFileSeq(name string) (uint64, bool) {
+	base, ok := strings.CutSuffix(name, ".json")
+	if !ok {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseUint(base, 10, 64)
+	return seq, err == nil
+}
+
+type offlineQueue struct {
+	mu    sync.Mutex
+	opts  OfflineQueueOptions
+	store QueueStore
+
+	// space is closed and replaced whenever a message leaves the queue or
+	// draining stops
+	space chan struct{}
+
+	draining bool
+	// sending is set while the drainer writes the front message, which
+	// then stays in the queue until it is written
+	sending bool
+}
+
+func (q *offlineQueue) full(size int) bool {
+	if q.opts.MaxMessages > 0 && q.store.Len()+1 > q.opts.MaxMessages {
+		return true
+	}
+	return q.opts.MaxBytes > 0 && q.store.Bytes()+size > q.opts.MaxBytes
+}
+
+func (q *offlineQueue) push(ctx context.Context, msg AppMessage) error {
+	size := messageSize(msg)
+	if q.opts.MaxBytes > 0 && size > q.opts.MaxBytes {
+		return fmt.Errorf("%w : message of %d bytes exceeds the %d bytes limit", ErrQueueFull, size, q.opts.MaxBytes)
+	}
+
+	for {
+		q.mu.Lock()
+		if !q.sending {
+			if err := q.dropExpired(time.Now()); err != nil {
+				q.mu.Unlock()
+				return err
+			}
+		}
+
+		if !q.full(size) {
+			err := q.store.Push(QueuedMessage{Message: msg, Queued: time.Now()})
+			q.mu.Unlock()
+			return err
+		}
+
+		switch {
+		case q.opts.Overflow == DropOldest && !q.sending:
+			err := q.popLocked()
+			q.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		case q.opts.Overflow != DropNewest:
+			// Block, or DropOldest while the front message is being sent
+			space := q.space
+			q.mu.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-space:
+			}
+		default:
+			q.mu.Unlock()
+			return ErrQueueFull
+		}
+	}
+}
+
+// dropExpired removes the expired messages at the front of the queue, q.mu
+// must be held.
+func (q *offlineQueue) dropExpired(now time.Time) error {
+	for {
+		qm, ok, err := q.store.Front()
+		if err != nil || !ok {
+			return err
+		}
+
+		if _, alive := qm.remaining(now); alive {
+			return nil
+		}
+
+		if err := q.popLocked(); err != nil {
+			return err
+		}
+	}
+}
+
+// popLocked removes the front message, q.mu must be held.
+func (q *offlineQueue) popLocked() error {
+	if err := q.store.Pop(); err != nil {
+		return fmt.Errorf("failed to remove queued message : %w", err)
+	}
+
+	q.signal()
+	return nil
+}
+
+// signal wakes the publishers waiting on q.space, q.mu must be held.
+func (q *offlineQueue) signal() {
+	close(q.space)
+	q.space = make(chan struct{})
+}
+
+func (q *offlineQueue) pop() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.sending = false
+	return q.popLocked()
+}
+
+// next returns the front message with its remaining expiry. The drainer
+// stops when it reports false, under the same lock a Publish waits on.
+func (q *offlineQueue) next() (AppMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	if err := q.dropExpired(now); err == nil {
+		if qm, ok, err := q.store.Front(); err == nil && ok {
+			msg, _ := qm.remaining(now)
+			q.sending = true
+			return msg, true
+		}
+	}
+
+	q.draining = false
+	q.signal()
+	return AppMessage{}, false
+}
+
+func (q *offlineQueue) stopDraining() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.draining = false
+	q.sending = false
+	q.signal()
+}
+
+// waitDrained waits for the queue to be sent, reporting errDrainStopped when
+// draining stops with messages left.
+func (q *offlineQueue) waitDrained(ctx context.Context) error {
+	for {
+		q.mu.Lock()
+		if q.store.Len() == 0 {
+			q.mu.Unlock()
+			return nil
+		}
+		if !q.draining {
+			q.mu.Unlock()
+			return errDrainStopped
+		}
+		space := q.space
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-space:
+		}
+	}
+}
+
+// offline reports whether a Publish failed because the server could not be
+// reached, rather than being refused.
+func offline(err error) bool {
+	var re *ReasonError
+	if errors.As(err, &re) {
+		return false
+	}
+
+	return !errors.Is(err, ErrClosing) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, ErrPacketTooLarge) &&
+		!errors.Is(err, ErrMessageExpired)
+}
+
+// enqueue queues msg and starts draining when the client is connected.
+func (pc *PorterClient) enqueue(ctx context.Context, msg AppMessage) error {
+	if err := pc.checkQueuedSize(msg); err != nil {
+		pc.logger.Warn("offline queue refused message", "topic", msg.TopicName, "error", err)
+		return err
+	}
+
+	if err := pc.queue.push(ctx, msg); err != nil {
+		pc.logger.Warn("offline queue refused message", "topic", msg.TopicName, "error", err)
+		return err
+	}
+	pc.logger.Debug("message queued offline", "topic", msg.TopicName)
+
+	if _, err := pc.current(); err == nil {
+		pc.drainQueue()
+	}
+	return nil
+}
+
+// drainQueue starts sending the queued messages unless it is already done.
+func (pc *PorterClient) drainQueue() {
+	q := pc.queue
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	if q.draining || q.store.Len() == 0 {
+		q.mu.Unlock()
+		return
+	}
+	q.draining = true
+	q.mu.Unlock()
+
+	go func() {
+		for {
+			msg, ok := q.next()
+			if !ok {
+				return
+			}
+
+			if err := pc.sendQueued(msg); err != nil {
+				if offline(err) {
+					// the message is sent by the next connection
+					q.stopDraining()
+					return
+				}
+
+				// a message the server can never accept is dropped as well
+				pc.logger.Error("dropping queued message", "topic", msg.TopicName, "error", err)
+			}
+
+			if err := q.pop(); err != nil {
+				pc.logger.Error("offline queue stopped draining", "error", err)
+				q.stopDraining()
+				return
+			}
+		}
+	}()
+}
+
+// sendQueued writes a queued message without waiting for its
+// acknowledgement, QoS 1 and 2 messages joining the in-flight exchanges.
+func (pc *PorterClient) sendQueued(msg AppMessage) error {
+	// msg carries the expiry left when it was taken from the queue
+	created := time.Now()
+
+	c, err := pc.current()
+	if err != nil {
+		return err
+	}
+
+	if msg.MessageQoS.level() == 0 {
+		return pc.writePublishOn(c, msg, 0, false)
+	}
+
+	if err := pc.acquireQuota(c.ctx, msg, created); err != nil {
+		return err
+	}
+
+	inf, err := pc.newInflight(msg, created)
+	if err != nil {
+		pc.quota.release()
+		return err
+	}
+
+	out, ok := remainingExpiry(inf.msg, inf.created, time.Now())
+	if !ok {
+		pc.dropInflight(inf, ErrMessageExpired)
+		return ErrMessageExpired
+	}
+
+	// once in flight the message belongs to the session and is sent again
+	// by the next connection, if one is to come
+	if err := pc.writePublishOn(c, out, inf.id, false); errors.Is(err, ErrPacketTooLarge) ||
+		err != nil && !pc.reconnectPending() {
+		pc.dropInflight(inf, err)
+		return err
+	}
+
+	// the publisher got its token when the message was queued, a refusal
+	// from the server is only logged
+	go func() {
+		<-inf.Done()
+		if err := inf.Err(); err != nil {
+			pc.logger.Error("queued message failed", "topic", msg.TopicName, "error", err)
+		}
+	}()
+	return nil
+}
+
+// checkQueuedSize refuses a message larger than the Maximum Packet Size of
+// the last connection, which the server would refuse once drained.
+func (pc *PorterClient) checkQueuedSize(msg AppMessage) error {
+	pc.mu.Lock()
+	version := pc.version
+	max := pc.serverMaxPacketSize
+	pc.mu.Unlock()
+
+	if max == 0 {
+		return nil
+	}
+
+	enc, err := buildPublish(version, msg, 1, false, topicAlias{})
+	if err != nil {
+		return err
+	}
+	return checkPacketSize(enc, max)
+}
@@ -0,0 +1,212 @@
+package portergosdk
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func queuedPayloads(t *testing.T, q QueueStore) []string {
+	t.Helper()
+
+	var payloads []string
+	for q.Len() > 0 {
+		qm, ok, err := q.Front()
+		if err != nil || !ok {
+			t.Fatalf("Front = %v, %v", ok, err)
+		}
+		payloads = append(payloads, string(qm.Message.Payload))
+
+		if err := q.Pop(); err != nil {
+			t.Fatalf("Pop : %v", err)
+		}
+	}
+	return payloads
+}
+
+func queuedMessage(payload string) QueuedMessage {
+	return QueuedMessage{
+		Message: AppMessage{TopicName: "t", Payload: []byte(payload)},
+		Queued:  time.Now(),
+	}
+}
+
+func TestQueueStores(t *testing.T) {
+	fq, err := NewFileQueue(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileQueue : %v", err)
+	}
+
+	for _, q := range []QueueStore{NewMemoryQueue(), fq} {
+		for _, p := range []string{"a", "bb", "ccc"} {
+			if err := q.Push(queuedMessage(p)); err != nil {
+				t.Fatalf("%T Push : %v", q, err)
+			}
+		}
+
+		if q.Len() != 3 || q.Bytes() != 9 {
+			t.Fatalf("%T holds %d messages of %d bytes, want 3 of 9", q, q.Len(), q.Bytes())
+		}
+
+		if got, want := queuedPayloads(t, q), []string{"a", "bb", "ccc"}; !slices.Equal(got, want) {
+			t.Fatalf("%T popped %v, want %v", q, got, want)
+		}
+
+		if q.Bytes() != 0 {
+			t.Fatalf("%T holds %d bytes once empty", q, q.Bytes())
+		}
+		if err := q.Pop(); err != nil {
+			t.Fatalf("%T Pop of an empty queue : %v", q, err)
+		}
+	}
+}
+
+func TestFileQueueReload(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("NewFileQueue : %v", err)
+	}
+	for _, p := range []string{"a", "b", "c"} {
+		if err := q.Push(queuedMessage(p)); err != nil {
+			t.Fatalf("Push : %v", err)
+		}
+	}
+	if err := q.Pop(); err != nil {
+		t.Fatalf("Pop : %v", err)
+	}
+
+	// files left by a crash or foreign to the queue are skipped
+	for _, name := range []string{".tmp-123", "00000000000000000009.json.tmp", "x.json", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reloaded, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatalf("NewFileQueue : %v", err)
+	}
+	if reloaded.Len() != 2 || reloaded.Bytes() != 4 {
+		t.Fatalf("reloaded %d messages of %d bytes, want 2 of 4", reloaded.Len(), reloaded.Bytes())
+	}
+
+	// messages pushed after a reload come after the ones kept
+	if err := reloaded.Push(queuedMessage("d")); err != nil {
+		t.Fatalf("Push : %v", err)
+	}
+
+	if got, want := queuedPayloads(t, reloaded), []string{"b", "c", "d"}; !slices.Equal(got, want) {
+		t.Fatalf("popped %v, want %v", got, want)
+	}
+}
+
+func TestOfflineQueueOverflow(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    OfflineQueueOptions
+		publish []string
+		want    []string
+		err     error
+	}{
+		{
+			name:    "drop oldest",
+			opts:    OfflineQueueOptions{MaxMessages: 2, Overflow: DropOldest},
+			publish: []string{"a", "b", "c"},
+			want:    []string{"b", "c"},
+		},
+		{
+			name:    "drop newest",
+			opts:    OfflineQueueOptions{MaxMessages: 2, Overflow: DropNewest},
+			publish: []string{"a", "b", "c"},
+			want:    []string{"a", "b"},
+			err:     ErrQueueFull,
+		},
+		{
+			name:    "block",
+			opts:    OfflineQueueOptions{MaxMessages: 2, Overflow: Block},
+			publish: []string{"a", "b", "c"},
+			want:    []string{"a", "b"},
+			err:     context.Canceled,
+		},
+		{
+			name:    "drop oldest by bytes",
+			opts:    OfflineQueueOptions{MaxBytes: 6, Overflow: DropOldest},
+			publish: []string{"aa", "bb", "cc"},
+			want:    []string{"bb", "cc"},
+		},
+		{
+			name:    "larger than the queue",
+			opts:    OfflineQueueOptions{MaxBytes: 4, Overflow: DropOldest},
+			publish: []string{"a", "bcdef"},
+			want:    []string{"a"},
+			err:     ErrQueueFull,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryQueue()
+			q := &offlineQueue{opts: tt.opts, store: store, space: make(chan struct{})}
+
+			// a blocked push gives up with its context
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			var err error
+			for _, p := range tt.publish {
+				if err = q.push(ctx, AppMessage{TopicName: "t", Payload: []byte(p)}); err != nil {
+					break
+				}
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("push = %v, want %v", err, tt.err)
+			}
+			if got := queuedPayloads(t, store); !slices.Equal(got, tt.want) {
+				t.Fatalf("queued %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOfflineQueueExpiry(t *testing.T) {
+	store := NewMemoryQueue()
+	q := &offlineQueue{opts: OfflineQueueOptions{MaxMessages: 2}, store: store, space: make(chan struct{})}
+
+	now := time.Now()
+	queued := []QueuedMessage{
+		{Message: AppMessage{TopicName: "t", Payload: []byte("expired"), Expiry: 5}, Queued: now.Add(-10 * time.Second)},
+		{Message: AppMessage{TopicName: "t", Payload: []byte("alive"), Expiry: 60}, Queued: now.Add(-20 * time.Second)},
+	}
+	for _, qm := range queued {
+		if err := store.Push(qm); err != nil {
+			t.Fatalf("Push : %v", err)
+		}
+	}
+
+	// the expired message makes room instead of the live one
+	if err := q.push(context.Background(), AppMessage{TopicName: "t", Payload: []byte("new")}); err != nil {
+		t.Fatalf("push : %v", err)
+	}
+
+	msg, ok := q.next()
+	if !ok {
+		t.Fatal("next found no message")
+	}
+	if string(msg.Payload) != "alive" || msg.Expiry < 39 || msg.Expiry > 40 {
+		t.Fatalf("next = %q expiring in %d, want %q expiring in 40", msg.Payload, msg.Expiry, "alive")
+	}
+
+	if err := q.pop(); err != nil {
+		t.Fatalf("pop : %v", err)
+	}
+	if got, want := queuedPayloads(t, store), []string{"new"}; !slices.Equal(got, want) {
+		t.Fatalf("queued %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,295 @@
+package portergosdk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/macdaih/porter_go_sdk/packets"
+)
+
+// ErrNotSuccess matches, through errors.Is, any *ReasonError whose code is
+// an error, 0x80 or above.
+var ErrNotSuccess = errors.New("reason code is not a success")
+
+type ReasonCode byte
+
+const (
+	ReasonSuccess                             ReasonCode = 0x00
+	ReasonNormalDisconnection                 ReasonCode = 0x00
+	ReasonGrantedQoS0                         ReasonCode = 0x00
+	ReasonGrantedQoS1                         ReasonCode = 0x01
+	ReasonGrantedQoS2                         ReasonCode = 0x02
+	ReasonDisconnectWithWillMessage           ReasonCode = 0x04
+	ReasonNoMatchingSubscribers               ReasonCode = 0x10
+	ReasonNoSubscriptionExisted               ReasonCode = 0x11
+	ReasonContinueAuthentication              ReasonCode = 0x18
+	ReasonReAuthenticate                      ReasonCode = 0x19
+	ReasonUnspecifiedError                    ReasonCode = 0x80
+	ReasonMalformedPacket                     ReasonCode = 0x81
+	ReasonProtocolError                       ReasonCode = 0x82
+	ReasonImplementationSpecificError         ReasonCode = 0x83
+	ReasonUnsupportedProtocolVersion          ReasonCode = 0x84
+	ReasonClientIdentifierNotValid            ReasonCode = 0x85
+	ReasonBadUserNameOrPassword               ReasonCode = 0x86
+	ReasonNotAuthorized                       ReasonCode = 0x87
+	ReasonServerUnavailable                   ReasonCode = 0x88
+	ReasonServerBusy                          ReasonCode = 0x89
+	ReasonBanned                              ReasonCode = 0x8A
+	ReasonServerShuttingDown                  ReasonCode = 0x8B
+	ReasonBadAuthenticationMethod             ReasonCode = 0x8C
+	ReasonKeepAliveTimeout                    ReasonCode = 0x8D
+	ReasonSessionTakenOver                    ReasonCode = 0x8E
+	ReasonTopicFilterInvalid                  ReasonCode = 0x8F
+	ReasonTopicNameInvalid                    ReasonCode = 0x90
+	ReasonPacketIdentifierInUse               ReasonCode = 0x91
+	ReasonPacketIdentifierNotFound            ReasonCode = 0x92
+	ReasonReceiveMaximumExceeded              ReasonCode = 0x93
+	ReasonTopicAliasInvalid                   ReasonCode = 0x94
+	ReasonPacketTooLarge                      ReasonCode = 0x95
+	ReasonMessageRateTooHigh                  ReasonCode = 0x96
+	ReasonQuotaExceeded                       ReasonCode = 0x97
+	ReasonAdministrativeAction                ReasonCode = 0x98
+	ReasonPayloadFormatInvalid                ReasonCode = 0x99
+	ReasonRetainNotSupported                  ReasonCode = 0x9A
+	ReasonQoSNotSupported                     ReasonCode = 0x9B
+	ReasonUseAnotherServer                    ReasonCode = 0x9C
+	ReasonServerMoved                         ReasonCode = 0x9D
+	ReasonSharedSubscriptionsNotSupported     ReasonCode = 0x9E
+	ReasonConnectionRateExceeded              ReasonCode = 0x9F
+	ReasonMaximumConnectTime                  ReasonCode = 0xA0
+	ReasonSubscriptionIdentifiersNotSupported ReasonCode = 0xA1
+	ReasonWildcardSubscriptionsNotSupported   ReasonCode = 0xA2
+)
+
+var reasonNames = map[ReasonCode]string{
+	ReasonSuccess:                             "Success",
+	ReasonGrantedQoS1:                         "Granted QoS 1",
+	ReasonGrantedQoS2:                         "Granted QoS 2",
+	ReasonDisconnectWithWillMessage:           "Disconnect with Will Message",
+	ReasonNoMatchingSubscribers:               "No matching subscribers",
+	ReasonNoSubscriptionExisted:               "No subscription existed",
+	ReasonContinueAuthentication:              "Continue authentication",
+	ReasonReAuthenticate:                      "Re-authenticate",
+	ReasonUnspecifiedError:                    "Unspecified error",
+	ReasonMalformedPacket:                     "Malformed Packet",
+	ReasonProtocolError:                       "Protocol Error",
+	ReasonImplementationSpecificError:         "Implementation specific error",
+	ReasonUnsupportedProtocolVersion:          "Unsupported Protocol Version",
+	ReasonClientIdentifierNotValid:            "Client Identifier not valid",
+	ReasonBadUserNameOrPassword:               "Bad User Name or Password",
+	ReasonNotAuthorized:                       "Not authorized",
+	ReasonServerUnavailable:                   "Server unavailable",
+	ReasonServerBusy:                          "Server busy",
+	ReasonBanned:                              "Banned",
+	ReasonServerShuttingDown:                  "Server shutting down",
+	ReasonBadAuthenticationMethod:             "Bad authentication method",
+	ReasonKeepAliveTimeout:                    "Keep Alive timeout",
+	ReasonSessionTakenOver:                    "Session taken over",
+	ReasonTopicFilterInvalid:                  "Topic Filter invalid",
+	ReasonTopicNameInvalid:                    "Topic Name invalid",
+	ReasonPacketIdentifierInUse:               "Packet Identifier in use",
+	ReasonPacketIdentifierNotFound:            "Packet Identifier not found",
+	ReasonReceiveMaximumExceeded:              "Receive Maximum exceeded",
+	ReasonTopicAliasInvalid:                   "Topic Alias invalid",
+	ReasonPacketTooLarge:                      "Packet too large",
+	ReasonMessageRateTooHigh:                  "Message rate too high",
+	ReasonQuotaExceeded:                       "Quota exceeded",
+	ReasonAdministrativeAction:                "Administrative action",
+	ReasonPayloadFormatInvalid:                "Payload format invalid",
+	ReasonRetainNotSupported:                  "Retain not supported",
+	ReasonQoSNotSupported:                     "QoS not supported",
+	ReasonUseAnotherServer:                    "Use another server",
+	ReasonServerMoved:                         "Server moved",
+	ReasonSharedSubscriptionsNotSupported:     "Shared Subscriptions not supported",
+	ReasonConnectionRateExceeded:              "Connection rate exceeded",
+	ReasonMaximumConnectTime:                  "Maximum connect time",
+	ReasonSubscriptionIdentifiersNotSupported: "Subscription Identifiers not supported",
+	ReasonWildcardSubscriptionsNotSupported:   "Wildcard Subscriptions not supported",
+}
+
+// reasonCodes lists the reason codes a packet type may carry, as defined in
+// section 2.4 of the MQTT 5 specification.
+var reasonCodes = map[CodeString][]ReasonCode{
+	CodeConnack: {
+		ReasonSuccess,
+		ReasonUnspecifiedError,
+		ReasonMalformedPacket,
+		ReasonProtocolError,
+		ReasonImplementationSpecificError,
+		ReasonUnsupportedProtocolVersion,
+		ReasonClientIdentifierNotValid,
+		ReasonBadUserNameOrPassword,
+		ReasonNotAuthorized,
+		ReasonServerUnavailable,
+		ReasonServerBusy,
+		ReasonBanned,
+		ReasonBadAuthenticationMethod,
+		ReasonTopicNameInvalid,
+		ReasonPacketTooLarge,
+		ReasonQuotaExceeded,
+		ReasonPayloadFormatInvalid,
+		ReasonRetainNotSupported,
+		ReasonQoSNotSupported,
+		ReasonUseAnotherServer,
+		ReasonServerMoved,
+		ReasonConnectionRateExceeded,
+	},
+	CodePubAck: {
+		ReasonSuccess,
+		ReasonNoMatchingSubscribers,
+		ReasonUnspecifiedError,
+		ReasonImplementationSpecificError,
+		ReasonNotAuthorized,
+		ReasonTopicNameInvalid,
+		ReasonPacketIdentifierInUse,
+		ReasonQuotaExceeded,
+		ReasonPayloadFormatInvalid,
+	},
+	CodePubRec: {
+		ReasonSuccess,
+		ReasonNoMatchingSubscribers,
+		ReasonUnspecifiedError,
+		ReasonImplementationSpecificError,
+		ReasonNotAuthorized,
+		ReasonTopicNameInvalid,
+		ReasonPacketIdentifierInUse,
+		ReasonQuotaExceeded,
+		ReasonPayloadFormatInvalid,
+	},
+	CodePubRel: {
+		ReasonSuccess,
+		ReasonPacketIdentifierNotFound,
+	},
+	CodePubComp: {
+		ReasonSuccess,
+		ReasonPacketIdentifierNotFound,
+	},
+	CodeSubAck: {
+		ReasonGrantedQoS0,
+		ReasonGrantedQoS1,
+		ReasonGrantedQoS2,
+		ReasonUnspecifiedError,
+		ReasonImplementationSpecificError,
+		ReasonNotAuthorized,
+		ReasonTopicFilterInvalid,
+		ReasonPacketIdentifierInUse,
+		ReasonQuotaExceeded,
+		ReasonSharedSubscriptionsNotSupported,
+		ReasonSubscriptionIdentifiersNotSupported,
+		ReasonWildcardSubscriptionsNotSupported,
+	},
+	CodeUnsubAck: {
+		ReasonSuccess,
+		ReasonNoSubscriptionExisted,
+		ReasonUnspecifiedError,
+		ReasonImplementationSpecificError,
+		ReasonNotAuthorized,
+		ReasonTopicFilterInvalid,
+		ReasonPacketIdentifierInUse,
+	},
+	CodeDisconnect: {
+		ReasonNormalDisconnection,
+		ReasonDisconnectWithWillMessage,
+		ReasonUnspecifiedError,
+		ReasonMalformedPacket,
+		ReasonProtocolError,
+		ReasonImplementationSpecificError,
+		ReasonNotAuthorized,
+		ReasonServerBusy,
+		ReasonServerShuttingDown,
+		ReasonKeepAliveTimeout,
+		ReasonSessionTakenOver,
+		ReasonTopicFilterInvalid,
+		ReasonTopicNameInvalid,
+		ReasonReceiveMaximumExceeded,
+		ReasonTopicAliasInvalid,
+		ReasonPacketTooLarge,
+		ReasonMessageRateTooHigh,
+		ReasonQuotaExceeded,
+		ReasonAdministrativeAction,
+		ReasonPayloadFormatInvalid,
+		ReasonRetainNotSupported,
+		ReasonQoSNotSupported,
+		ReasonUseAnotherServer,
+		ReasonServerMoved,
+		ReasonSharedSubscriptionsNotSupported,
+		ReasonConnectionRateExceeded,
+		ReasonMaximumConnectTime,
+		ReasonSubscriptionIdentifiersNotSupported,
+		ReasonWildcardSubscriptionsNotSupported,
+	},
+	CodeAuth: {
+		ReasonSuccess,
+		ReasonContinueAuthentication,
+		ReasonReAuthenticate,
+	},
+}
+
+func (rc ReasonCode) String() string {
+	if name, ok := reasonNames[rc]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown reason code 0x%02X", byte(rc))
+}
+
+// Error lets a ReasonCode be used as an errors.Is target.
+func (rc ReasonCode) Error() string {
+	return rc.String()
+}
+
+func (rc ReasonCode) IsError() bool {
+	return rc >= ReasonUnspecifiedError
+}
+
+// ValidFor reports whether the reason code may be carried by the given packet.
+func (rc ReasonCode) ValidFor(code CodeString) bool {
+	for _, valid := range reasonCodes[code] {
+		if valid == rc {
+			return true
+		}
+	}
+	return false
+}
+
+type ReasonError struct {
+	Packet         CodeString
+	Code           ReasonCode
+	Reason         string
+	UserProperties []UserProperty
+}
+
+func newReasonError(packet CodeString, code ReasonCode, props packets.Properties) *ReasonError {
+	return &ReasonError{
+		Packet:         packet,
+		Code:           code,
+		Reason:         props.ReasonString,
+		UserProperties: props.UserProperties,
+	}
+}
+
+func (e *ReasonError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s failed with reason code 0x%02X (%s)", e.Packet, byte(e.Code), e.Code)
+	if e.Reason != "" {
+		sb.WriteString(" : ")
+		sb.WriteString(e.Reason)
+	}
+	return sb.String()
+}
+
+func (e *ReasonError) Unwrap() error {
+	return e.Code
+}
+
+func (e *ReasonError) Is(target error) bool {
+	if target == ErrNotSuccess {
+		return e.Code.IsError()
+	}
+
+	t, ok := target.(*ReasonError)
+	if !ok {
+		return false
+	}
+	return t.Code == e.Code && (t.Packet == "" || t.Packet == e.Packet)
+}
@@ -0,0 +1,72 @@
+package portergosdk
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultReconnectAttempts = 5
+	defaultReconnectBackoff  = time.Second
+	maxReconnectBackoff      = 30 * time.Second
+)
+
+// WithReconnect sets how many times the client tries to restore a lost
+// connection, waiting backoff before the first attempt and doubling it on
+// each failure. An attempts value of 0 disables reconnection.
+func WithReconnect(attempts int, backoff time.Duration) Option {
+	return func(c *PorterClient) {
+		c.reconnectAttempts = attempts
+		c.reconnectBackoff = backoff
+	}
+}
+
+// reconnectPending reports whether a lost connection is restored, the
+// in-flight exchanges being sent again by the next one.
+func (pc *PorterClient) reconnectPending() bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	return pc.reconnectAttempts > 0 &&
+		!pc.closing &&
+		pc.done != nil && !isClosed(pc.done)
+}
+
+// reconnectWithBackoff tries to restore a lost connection, giving up once the
+// client is stopped.
+func (pc *PorterClient) reconnectWithBackoff() error {
+	backoff := pc.reconnectBackoff
+	done := pc.stopped()
+
+	var err error
+	for attempt := 0; attempt < pc.reconnectAttempts; attempt++ {
+		pc.logger.Info("reconnecting", "attempt", attempt+1, "backoff", backoff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return ErrClosed
+		}
+
+		if err = pc.reconnect(); err == nil {
+			pc.logger.Info("reconnected", "attempt", attempt+1)
+			return nil
+		}
+		if errors.Is(err, ErrClosing) || errors.Is(err, ErrClosed) {
+			return err
+		}
+		pc.logger.Warn("reconnect failed", "attempt", attempt+1, "error", err)
+
+		// the server refused the connection, retrying will not help
+		var re *ReasonError
+		if errors.As(err, &re) {
+			return err
+		}
+
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+
+	return err
+}
@@ -0,0 +1,163 @@
+package portergosdk
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+const defaultReplyPrefix = "porter/replies"
+
+var ErrRequestUnsupported = errors.New("request/response needs MQTT 5")
+
+// Request publishes msg with a Response Topic and Correlation Data and waits
+// for the matching response. Responses are received on a topic of their own,
+// prefixed with the Response Information of CONNACK when the server sends
+// one.
+func (pc *PorterClient) Request(ctx context.Context, msg AppMessage) (AppMessage, error) {
+	connCtx, cancel := withTimedContext(ctx, pc.sessionDuration)
+	defer cancel()
+
+	if err := pc.ensureConnected(connCtx); err != nil {
+		return AppMessage{}, err
+	}
+
+	done := pc.stopped()
+
+	topic, err := pc.replyTopic()
+	if err != nil {
+		return AppMessage{}, err
+	}
+
+	if err := pc.subscribe(connCtx, []string{topic}); err != nil {
+		return AppMessage{}, err
+	}
+
+	correlation, err := randomID()
+	if err != nil {
+		return AppMessage{}, err
+	}
+
+	res := make(chan AppMessage, 1)
+
+	pc.mu.Lock()
+	pc.requests[correlation] = res
+	pc.mu.Unlock()
+
+	defer func() {
+		pc.mu.Lock()
+		delete(pc.requests, correlation)
+		pc.mu.Unlock()
+	}()
+
+	msg.ResponseTopic = topic
+	msg.Correlation = correlation
+
+	if err := pc.publish(connCtx, msg).wait(connCtx); err != nil {
+		return AppMessage{}, err
+	}
+
+	select {
+	case <-connCtx.Done():
+		return AppMessage{}, connCtx.Err()
+	case <-done:
+		if err := pc.stopErr(); err != nil {
+			return AppMessage{}, err
+		}
+		return AppMessage{}, ErrClosed
+	case msg := <-res:
+		return msg, nil
+	}
+}
+
+// replyTopic returns the topic this client receives its responses on.
+func (pc *PorterClient) replyTopic() (string, error) {
+	c, err := pc.current()
+	if err != nil {
+		return "", err
+	}
+
+	if c.version < V5 {
+		return "", ErrRequestUnsupported
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.replies != "" {
+		return pc.replies, nil
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+
+	prefix := defaultReplyPrefix
+	if c.responseInfo != "" {
+		prefix = strings.TrimSuffix(c.responseInfo, "/")
+	}
+
+	pc.replies = prefix + "/" + id
+	return pc.replies, nil
+}
+
+// completeRequest hands a response to the pending Request it correlates to.
+func (pc *PorterClient) completeRequest(msg AppMessage) bool {
+	if msg.Correlation == "" {
+		return false
+	}
+
+	pc.mu.Lock()
+	res, ok := pc.requests[msg.Correlation]
+	ok = ok && msg.TopicName == pc.replies
+	if ok {
+		delete(pc.requests, msg.Correlation)
+	}
+	pc.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	res <- msg
+	return true
+}
+
+// HandleRequest subscribes to filter and answers the requests it receives
+// with the message returned by fn, published to their Response Topic with
+// their Correlation Data. Messages without a Response Topic are ignored.
+func (pc *PorterClient) HandleRequest(
+	ctx context.Context,
+	filter string,
+	fn func(ctx context.Context, req AppMessage) (AppMessage, error),
+	mws ...Middleware,
+) error {
+	h := func(ctx context.Context, req AppMessage) error {
+		if req.ResponseTopic == "" {
+			return nil
+		}
+
+		res, err := fn(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		res.TopicName = req.ResponseTopic
+		res.Correlation = req.Correlation
+
+		return pc.publishNoWait(ctx, res)
+	}
+
+	return pc.Handle(ctx, filter, h, mws...)
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
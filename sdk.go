@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
-	"net"
+	"log/slog"
+	"sync"
 	"time"
+
+	"github.com/macdaih/porter_go_sdk/packets"
+	"github.com/macdaih/porter_go_sdk/topic"
 )
 
 type QoS uint8
@@ -17,6 +20,29 @@ const (
 	QoSTwo  = 0x18
 )
 
+// level returns the QoS as carried by PUBLISH and SUBSCRIBE packets.
+func (q QoS) level() byte {
+	switch q {
+	case QoSOne:
+		return 1
+	case QoSTwo:
+		return 2
+	default:
+		return 0
+	}
+}
+
+func qosFromLevel(level byte) QoS {
+	switch level {
+	case 1:
+		return QoSOne
+	case 2:
+		return QoSTwo
+	default:
+		return QoSZero
+	}
+}
+
 const maxSubscription = 10
 
 type credential struct {
@@ -27,39 +53,85 @@ type credential struct {
 
 type SubscribeCallback func() error
 
-type endState struct {
-	err    error
-	status string
-	reason string
-}
-
 type PorterClient struct {
 	serverHost string
-	conn       *net.TCPConn
-	connOpen   bool
+
+	mu sync.Mutex
+	// dialMu is held while dialing and resuming a connection, a channel so
+	// waiting for it can be given up with the context.
+	dialMu chan struct{}
+	cur    *connection
+
+	// done is closed once the client stops, either through Disconnect or
+	// because the connection could not be recovered.
+	done    chan struct{}
+	doneErr error
+	closing bool
 
 	clientID string
 
-	keepAlive uint16
+	keepAlive   uint16
+	pingTimeout time.Duration
 
-	willFlag uint8
+	will *will
 
 	cleanStart bool
 
 	qos QoS
 
 	nextPacketID uint16
+	sendSeq      uint64
+	outbound     map[uint16]*inflight
+	inbound      map[uint16]struct{}
+	quota        *sendQuota
+	pendingSubs  map[uint16]*pendingSub
+	drained      chan struct{}
 
-	creds *credential
+	creds         *credential
+	credsProvider CredentialsProvider
+	credsTimeout  time.Duration
 
 	receivedMax     int
 	sessionDuration time.Duration
 	sessionExpiry   uint32
-	messageHandler  func(context.Context, AppMessage) error
+	messageHandler  Handler
+	middlewares     []Middleware
+	routes          []*route
+
+	// replies is the topic the responses to Request are received on
+	replies  string
+	requests map[string]chan AppMessage
 
 	subscribed map[string]uint8
+	channels   map[*Subscription]struct{}
+
+	disconnectHandler func(context.Context, Disconnect)
+	maxRedirects      int
+	redirects         int
+
+	reconnectAttempts int
+	reconnectBackoff  time.Duration
+
+	aliasPolicy   TopicAliasPolicy
+	topicAliasMax uint16
 
-	endState chan endState
+	maxPacketSize uint32
+	// serverMaxPacketSize is the Maximum Packet Size of the last connection,
+	// the offline queue refusing the messages that cannot fit in it
+	serverMaxPacketSize uint32
+
+	version  ProtocolVersion
+	fallback bool
+
+	store SessionStore
+	// storeTails holds, per packet, the end of the last session store call
+	storeTails map[storeKey]chan struct{}
+	queue      *offlineQueue
+
+	workerOpts WorkerOptions
+
+	logger        *slog.Logger
+	payloadLogMax int
 }
 
 type Option func(c *PorterClient)
@@ -73,22 +145,27 @@ func WithID(id string) Option {
 func WithBasicCredentials(user string, pwd string) Option {
 	return func(c *PorterClient) {
 		c.creds = &credential{
-			authMethod: PasswordMethod,
-			usr:        &user,
-			pwd:        &pwd,
+			usr: &user,
+			pwd: &pwd,
 		}
 	}
 }
 
-func WithMaxMessage(max int) Option {
+// WithCallBack sets the handler called for every inbound message. It runs on
+// the connection reader, or on a worker with WithWorkers, so a Publish from it
+// returns without waiting for the acknowledgement while Subscribe, Unsubscribe
+// and Request, which wait for the server, must not be called from it.
+func WithCallBack(fn func(ctx context.Context, msg AppMessage) error) Option {
 	return func(c *PorterClient) {
-		c.receivedMax = max
+		c.messageHandler = fn
 	}
 }
 
-func WithCallBack(fn func(ctx context.Context, msg AppMessage) error) Option {
+// WithWill registers a message the server publishes when the connection ends
+// without a normal DISCONNECT, after delay seconds.
+func WithWill(msg AppMessage, delay uint32) Option {
 	return func(c *PorterClient) {
-		c.messageHandler = fn
+		c.will = &will{msg: msg, delay: delay}
 	}
 }
 
@@ -111,279 +188,353 @@ func NewClient(
 	}
 
 	pc := PorterClient{
-		serverHost:     serverHost,
-		keepAlive:      keepAlive,
-		receivedMax:    10,
-		qos:            qos,
-		sessionExpiry:  sessionExpiry,
-		messageHandler: func(_ context.Context, _ AppMessage) error { return nil },
-		subscribed:     make(map[string]uint8),
+		serverHost:    serverHost,
+		keepAlive:     keepAlive,
+		pingTimeout:   defaultPingTimeout,
+		receivedMax:   10,
+		qos:           qos,
+		sessionExpiry: sessionExpiry,
+		subscribed:    make(map[string]uint8),
+		channels:      make(map[*Subscription]struct{}),
+		requests:      make(map[string]chan AppMessage),
+		outbound:      make(map[uint16]*inflight),
+		inbound:       make(map[uint16]struct{}),
+		pendingSubs:   make(map[uint16]*pendingSub),
+		drained:       make(chan struct{}, 1),
+		dialMu:        make(chan struct{}, 1),
+		quota:         newSendQuota(),
+		storeTails:    make(map[storeKey]chan struct{}),
+
+		reconnectAttempts: defaultReconnectAttempts,
+		reconnectBackoff:  defaultReconnectBackoff,
+		aliasPolicy:       NewLRUAliasPolicy(),
+		version:           V5,
+		logger:            slog.New(discardHandler{}),
 	}
 
 	for _, fn := range options {
 		fn(&pc)
 	}
 
+	if pc.messageHandler != nil {
+		pc.messageHandler = chain(pc.messageHandler, pc.middlewares...)
+	}
+
 	return &pc
 }
 
-func (pc *PorterClient) connect(ctx context.Context, es chan endState) error {
-	if err := pc.conn.SetReadDeadline(
-		time.Now().Add(time.Duration(pc.keepAlive) * time.Second),
-	); err != nil {
-		return err
+// Publish writes msg and waits for its acknowledgement. Called from a message
+// handler it returns once msg is validated, msg being published on its own
+// goroutine and its failure logged.
+func (pc *PorterClient) Publish(ctx context.Context, msg AppMessage) error {
+	if inHandler(ctx) {
+		return pc.publishNoWait(ctx, msg)
 	}
 
-	msg, err := buildConnect(
-		pc.clientID,
-		pc.keepAlive,
-		pc.creds,
-		pc.qos,
-		pc.sessionExpiry,
-	)
+	connCtx, cancel := withTimedContext(ctx, pc.sessionDuration)
+	defer cancel()
+
+	return pc.publish(connCtx, msg).wait(connCtx)
+}
 
-	if err != nil {
-		return err
+// PublishAsync writes msg and returns without waiting for its
+// acknowledgement, so QoS 1 and 2 messages can be pipelined. It still blocks
+// while connecting and while the server's Receive Maximum window is full.
+// A message put in the offline queue completes its token right away. Called
+// from a message handler it returns at once, msg being published on its own
+// goroutine.
+func (pc *PorterClient) PublishAsync(ctx context.Context, msg AppMessage) Token {
+	if inHandler(ctx) {
+		return pc.publishOffHandler(ctx, msg)
 	}
 
-	// no closed conn
-	if _, err := pc.conn.Write(msg); err != nil {
+	connCtx, cancel := withTimedContext(ctx, pc.sessionDuration)
+	defer cancel()
+
+	return pc.publish(connCtx, msg)
+}
+
+// publishNoWait publishes from a handler, which must wait neither for an
+// acknowledgement nor for room in the send quota as both are freed by the
+// connection reader it may run on. An invalid message is reported right
+// away, the message is then published on its own goroutine and its failure
+// logged.
+func (pc *PorterClient) publishNoWait(ctx context.Context, msg AppMessage) error {
+	if err := topic.ValidateName(msg.TopicName); err != nil {
 		return err
 	}
-
-	// read connack
-	connbuff := make([]byte, 1024)
-	if _, err := pc.conn.Read(connbuff); err != nil {
+	if err := checkPayloadFormat(msg); err != nil {
 		return err
 	}
 
-	if connbuff[0] != 0x20 {
-		return fmt.Errorf("unexpected packet response code")
-	}
+	tok := pc.publishOffHandler(ctx, msg)
+	go func() {
+		<-tok.Done()
+		if err := tok.Err(); err != nil {
+			pc.logger.Error("publish failed", "topic", msg.TopicName, "error", err)
+		}
+	}()
 
-	res, err := readConnack(connbuff)
-	if err != nil {
-		return err
-	}
+	return nil
+}
 
-	if res.code > 0 {
-		return fmt.Errorf("failed to connect to broker : %s", res.description)
-	}
+// publishOffHandler publishes msg on its own goroutine, the returned token
+// completing with the exchange.
+func (pc *PorterClient) publishOffHandler(ctx context.Context, msg AppMessage) *token {
+	// the handler context ends with the handler
+	ctx = context.WithValue(context.WithoutCancel(ctx), handlerKey{}, false)
 
+	tok := newToken()
 	go func() {
-		ctx, cancel := context.WithCancel(ctx)
-		defer cancel()
-		for {
-			if !pc.connOpen {
-				es <- endState{}
-				return
-			}
+		t := pc.PublishAsync(ctx, msg)
+		<-t.Done()
+		tok.complete(t.ReasonCode(), t.Err())
+	}()
 
-			buff := make([]byte, 1024)
-			if _, err := pc.conn.Read(buff); err != nil {
-				e, ok := err.(net.Error)
-				if ok && e.Timeout() {
-					ping := []byte{0xC0, 0}
-					if _, err := pc.conn.Write(ping); err != nil {
-						es <- endState{err: err}
-						return
-					}
-
-					if err := pc.conn.SetReadDeadline(
-						time.Now().Add(time.Duration(pc.keepAlive) * time.Second),
-					); err != nil {
-						es <- endState{err: err}
-						return
-					}
-
-					continue
-				}
+	return tok
+}
 
-				if errors.Is(err, io.EOF) {
-					es <- endState{}
-					return
-				}
+func (pc *PorterClient) publish(ctx context.Context, msg AppMessage) *token {
+	// the message expires from when it was published, waiting to connect or
+	// for room in the send quota included
+	created := time.Now()
 
-				es <- endState{err: err}
-				return
-			}
+	if err := topic.ValidateName(msg.TopicName); err != nil {
+		return completedToken(ReasonTopicNameInvalid, err)
+	}
 
-			pkt, err := newPacket(buff)
-			if err != nil {
-				es <- endState{err: err}
-				return
-			}
-			pc.readMessage(ctx, pkt, es)
+	if err := checkPayloadFormat(msg); err != nil {
+		return completedToken(ReasonPayloadFormatInvalid, err)
+	}
+
+	if err := pc.ensureConnected(ctx); err != nil {
+		if pc.queue != nil && offline(err) {
+			return completedToken(ReasonSuccess, pc.enqueue(ctx, msg))
 		}
-	}()
+		return completedToken(ReasonUnspecifiedError, err)
+	}
 
-	return nil
-}
+	// messages published after queued ones wait for them to be sent to keep
+	// the order, rather than joining the queue and evicting its messages.
+	// When a lost connection stopped the draining, msg is queued behind them.
+	if pc.queue != nil {
+		pc.drainQueue()
+		if err := pc.queue.waitDrained(ctx); errors.Is(err, errDrainStopped) {
+			return completedToken(ReasonSuccess, pc.enqueue(ctx, msg))
+		} else if err != nil {
+			return completedToken(ReasonUnspecifiedError, err)
+		}
+	}
 
-	func (pc *PorterClient) Publish(ctx context.Context, msg AppMessage) error {
-	es := make(chan endState, 1)
-	connCtx, cancel := withTimedContext(ctx, pc.sessionDuration)
-	defer cancel()
+	if msg.MessageQoS.level() == 0 {
+		err := pc.writePublish(msg, 0, false)
+		if err != nil && pc.queue != nil && offline(err) {
+			err = pc.enqueue(ctx, msg)
+		}
+		if err != nil {
+			return completedToken(ReasonUnspecifiedError, err)
+		}
+		return completedToken(ReasonSuccess, nil)
+	}
 
-	addr, err := net.ResolveTCPAddr("tcp", pc.serverHost)
-	if err != nil {
-		return err
+	if err := pc.acquireQuota(ctx, msg, created); err != nil {
+		return completedToken(ReasonUnspecifiedError, err)
 	}
 
-	conn, err := net.DialTCP("tcp", nil, addr)
+	inf, err := pc.newInflight(msg, created)
 	if err != nil {
-		return err
+		pc.quota.release()
+		return completedToken(ReasonUnspecifiedError, err)
 	}
 
-	pc.conn = conn
-	pc.connOpen = true
+	out, ok := remainingExpiry(inf.msg, inf.created, time.Now())
+	if !ok {
+		pc.dropInflight(inf, ErrMessageExpired)
+		return inf.token
+	}
 
-	defer func() {
-		pc.conn.Close()
-		pc.connOpen = false
-	}()
+	// a packet refused before being written is not part of the session,
+	// otherwise the exchange is resent by the next connection and its token
+	// completes with it. Without a connection to come it fails right away.
+	if err := pc.writePublish(out, inf.id, false); errors.Is(err, ErrPacketTooLarge) ||
+		err != nil && !pc.reconnectPending() {
+		pc.dropInflight(inf, err)
+	}
+
+	return inf.token
+}
+
+func (pc *PorterClient) Subscribe(ctx context.Context, topics []string) error {
+	connCtx, cancel := withTimedContext(ctx, pc.sessionDuration)
+	defer cancel()
 
-	if err := pc.connect(connCtx, es); err != nil {
+	if err := validateFilters(topics); err != nil {
 		return err
 	}
 
-	enc, err := buildPublish(msg)
-	if err != nil {
+	if err := pc.ensureConnected(connCtx); err != nil {
 		return err
 	}
 
-	if _, err := pc.conn.Write(enc); err != nil {
+	// the context ending first keeps ending Subscribe without error
+	if err := pc.subscribe(connCtx, topics); err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil
+		}
 		return err
 	}
 
 	select {
 	case <-connCtx.Done():
-		es <- endState{}
-		return nil
-	case end := <-es:
-		if end.err != nil {
-			if errors.Is(end.err, net.ErrClosed) {
-				return nil
-			}
-		}
 		return nil
+	case <-pc.stopped():
+		return pc.stopErr()
 	}
 }
 
-type topicsKeyType string
-
-const topicsKey topicsKeyType = "topics"
+// subscribe sends a SUBSCRIBE for the topics not subscribed yet and waits
+// for its SUBACK.
+func (pc *PorterClient) subscribe(ctx context.Context, topics []string) error {
+	if err := validateFilters(topics); err != nil {
+		return err
+	}
 
-func withTopics(ctx context.Context, topics []string) context.Context {
-	return context.WithValue(ctx, topicsKey, topics)
-}
+	if err := pc.ensureConnected(ctx); err != nil {
+		return err
+	}
 
-func (pc *PorterClient) Subscribe(ctx context.Context, topics []string) error {
-	es := make(chan endState, 1)
-	connCtx, cancel := withTimedContext(ctx, pc.sessionDuration)
-	defer cancel()
+	done := pc.stopped()
 
 	newTopics := make([]string, 0, len(topics))
 
+	pc.mu.Lock()
 	for _, topic := range topics {
 		if _, ok := pc.subscribed[topic]; !ok {
 			newTopics = append(newTopics, topic)
 		}
 	}
-	connCtx = withTopics(connCtx, newTopics)
+	pc.mu.Unlock()
 
-	addr, err := net.ResolveTCPAddr("tcp4", pc.serverHost)
-	if err != nil {
-		return err
+	if len(newTopics) == 0 {
+		return nil
 	}
 
-	conn, err := net.DialTCP("tcp", nil, addr)
+	sub, err := pc.sendSubscribe(newTopics)
 	if err != nil {
 		return err
 	}
 
-	pc.conn = conn
-	pc.connOpen = true
-
-	defer func() {
-		pc.conn.Close()
-		pc.connOpen = false
-	}()
-
-	if err := pc.connect(connCtx, es); err != nil {
-		return err
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return pc.stopErr()
+	case <-sub.done:
+		return sub.err
 	}
+}
+
+func (pc *PorterClient) readMessage(c *connection, pkt packets.Packet) error {
+	ctx := c.ctx
 
-	if len(newTopics) > 0 {
-		msg, err := buildSubscribe(newTopics, 1)
+	switch p := pkt.(type) {
+	case *packets.Disconnect:
+		d, err := readDisconnect(p)
 		if err != nil {
 			return err
 		}
 
-		if _, err := pc.conn.Write(msg); err != nil {
-			return err
-		}
-	}
+		c.logger.Warn("server disconnected", "reason_code", int(d.ReasonCode), "reason", d.Reason)
 
-	select {
-	case <-connCtx.Done():
-		es <- endState{}
-		_, err := pc.conn.Write([]byte{224, 0})
-		return err
-	case end := <-es:
-		if end.err != nil {
-			if _, err := pc.conn.Write([]byte{224, 0}); err != nil {
-				return err
-			}
-			if errors.Is(end.err, net.ErrClosed) {
-				return nil
-			}
-			return end.err
+		if pc.disconnectHandler != nil {
+			pc.disconnectHandler(ctx, d)
 		}
-		return nil
-	}
-}
 
-func (pc *PorterClient) readMessage(ctx context.Context, pkt *packet, es chan endState) {
-	switch pkt.cmd {
-	case disconnectcmd:
-		// TODO read disconnect code
-		es <- endState{}
-		return
-	case publishcmd: // TODO handle pub flags
-		msg, err := readPublish(pkt)
+		return &DisconnectError{Disconnect: d}
+	case *packets.Publish:
+		msg, id := readPublish(p)
+
+		topic, code, err := c.inAliases.resolve(msg.TopicName, p.Properties)
 		if err != nil {
-			es <- endState{err: err}
-			return
+			return c.disconnectWithError(code, err.Error())
 		}
-		if err := pc.messageHandler(ctx, msg); err != nil {
-			es <- endState{err: err}
-			return
-		}
-	case subackcmd:
-		topics, ok := ctx.Value(topicsKey).([]string)
-		if !ok {
-			//
+		msg.TopicName = topic
+
+		c.logger.Debug("publish received",
+			"topic", msg.TopicName,
+			"qos", int(msg.MessageQoS.level()),
+			"packet_id", id,
+			pc.payloadAttr(msg.Payload),
+		)
+
+		if err := checkPayloadFormat(msg); err != nil {
+			c.logger.Warn("refusing publish", "topic", msg.TopicName, "packet_id", id, "error", err)
+			return refusePublish(c, msg.MessageQoS, id, ReasonPayloadFormatInvalid)
 		}
 
-		b, err := readSubAck(pkt)
-		if err != nil {
-			es <- endState{err: err}
-			return
-		}
+		// the acknowledgement is only sent once the message was handled. A
+		// failed handler is acknowledged with Unspecified error, a plain
+		// acknowledgement on MQTT 3.1.1, so the server does not keep it in
+		// flight
+		switch msg.MessageQoS.level() {
+		case 0:
+			return pc.handle(c, msg, func(ctx context.Context) error {
+				pc.dispatch(ctx, msg)
+				return nil
+			})
+		case 1:
+			if !pc.receiving(c) {
+				return c.disconnectWithError(ReasonReceiveMaximumExceeded, "receive maximum exceeded")
+			}
 
-		if len(topics) != len(b) {
-			return
-		}
+			return pc.handle(c, msg, func(ctx context.Context) error {
+				defer pc.handled(c)
 
-		for idx, topic := range topics {
-			if b[idx] >= 0 && b[idx] <= 2 {
-				pc.subscribed[topic] = uint8(b[idx])
+				if !pc.dispatch(ctx, msg) {
+					return refusePublish(c, msg.MessageQoS, id, ReasonUnspecifiedError)
+				}
+				return c.sendAck(pubackcmd, id, ReasonSuccess)
+			})
+		default:
+			// a duplicate of a delivered message is already counted through
+			// its packet identifier
+			if pc.duplicateQoS2(id) {
+				return pc.handle(c, msg, func(context.Context) error {
+					return c.sendAck(pubreccmd, id, ReasonSuccess)
+				})
+			}
+
+			if !pc.receiving(c) {
+				return c.disconnectWithError(ReasonReceiveMaximumExceeded, "receive maximum exceeded")
 			}
+
+			return pc.handle(c, msg, func(ctx context.Context) error {
+				defer pc.handled(c)
+
+				// a PUBREC on MQTT 3.1.1 cannot refuse the message, the
+				// exchange going on to PUBREL as for a handled one
+				if !pc.dispatch(ctx, msg) && c.version == V5 {
+					return refusePublish(c, msg.MessageQoS, id, ReasonUnspecifiedError)
+				}
+				if err := pc.deliveredQoS2(id); err != nil {
+					return err
+				}
+				return c.sendAck(pubreccmd, id, ReasonSuccess)
+			})
 		}
+	case *packets.Puback:
+		return pc.handleAck(pubackcmd, p.PacketID, ReasonCode(p.ReasonCode), p.Properties)
+	case *packets.Pubrec:
+		return pc.handleAck(pubreccmd, p.PacketID, ReasonCode(p.ReasonCode), p.Properties)
+	case *packets.Pubrel:
+		return pc.handleAck(pubrelcmd, p.PacketID, ReasonCode(p.ReasonCode), p.Properties)
+	case *packets.Pubcomp:
+		return pc.handleAck(pubcompcmd, p.PacketID, ReasonCode(p.ReasonCode), p.Properties)
+	case *packets.Suback:
+		pc.completeSubscribe(p.PacketID, readSubAck(p), p.Properties)
+		return nil
 	default:
-		es <- endState{}
-		return
+		return fmt.Errorf("%w : unexpected %s packet", ErrInvalidCommand, pkt.Type())
 	}
 }
 
@@ -0,0 +1,44 @@
+package portergosdk
+
+import (
+	"fmt"
+
+	"github.com/macdaih/porter_go_sdk/packets"
+)
+
+var ErrPacketTooLarge = packets.ErrPacketTooLarge
+
+// WithMaxPacketSize advertises the largest packet the client accepts, bigger
+// packets make the client disconnect with Packet too large.
+func WithMaxPacketSize(max uint32) Option {
+	return func(c *PorterClient) {
+		c.maxPacketSize = max
+	}
+}
+
+func checkPacketSize(b []byte, max uint32) error {
+	if max > 0 && len(b) > int(max) {
+		return fmt.Errorf("%w : %d bytes over %d", ErrPacketTooLarge, len(b), max)
+	}
+	return nil
+}
+
+// fitDisconnect drops the user properties, then the reason string, until the
+// DISCONNECT packet fits in the server's maximum packet size.
+func fitDisconnect(version ProtocolVersion, opts DisconnectOptions, max uint32) ([]byte, error) {
+	enc, err := buildDisconnect(version, opts)
+	if err != nil || checkPacketSize(enc, max) == nil {
+		return enc, err
+	}
+
+	if len(opts.UserProperties) > 0 {
+		opts.UserProperties = nil
+		enc, err = buildDisconnect(version, opts)
+		if err != nil || checkPacketSize(enc, max) == nil {
+			return enc, err
+		}
+	}
+
+	opts.Reason = ""
+	return buildDisconnect(version, opts)
+}
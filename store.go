@@ -0,0 +1,444 @@
+package portergosdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Direction uint8
+
+const (
+	// Outbound entries are QoS 1 and 2 messages published by the client and
+	// not yet acknowledged.
+	Outbound Direction = iota
+	// Inbound entries are the packet identifiers of QoS 2 messages received
+	// by the client and not yet released.
+	Inbound
+)
+
+func (d Direction) String() string {
+	if d == Inbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// StoredPacket is an in-flight exchange kept by a SessionStore.
+type StoredPacket struct {
+	Direction Direction
+	PacketID  uint16
+	// Released is set once an outbound QoS 2 message was acknowledged by
+	// PUBREC, only PUBREL is then sent again.
+	Released bool
+	// Created is when an outbound exchange started, for its message expiry.
+	Created time.Time
+	// Seq orders the outbound exchanges as they were sent, packet
+	// identifiers wrapping around.
+	Seq uint64
+	// Message is only set for outbound entries.
+	Message AppMessage
+}
+
+// SessionStore persists the in-flight exchanges of a session so they can be
+// replayed on reconnect, and after a restart when the client connects with a
+// session expiry interval. The calls for a packet are made in order, the
+// ones for different packets may run concurrently.
+type SessionStore interface {
+	Put(pkt StoredPacket) error
+	Get(dir Direction, id uint16) (StoredPacket, bool, error)
+	Delete(dir Direction, id uint16) error
+	// Iterate calls fn for every entry, outbound entries first in the order
+	// they were sent, until fn returns false.
+	Iterate(fn func(StoredPacket) bool) error
+}
+
+// WithSessionStore persists the in-flight exchanges in store, they are
+// loaded and replayed when the client connects. A Publish that failed because
+// the client stopped may then still be delivered by a later connection.
+func WithSessionStore(store SessionStore) Option {
+	return func(c *PorterClient) {
+		c.store = store
+	}
+}
+
+type storeKey struct {
+	dir Direction
+	id  uint16
+}
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[storeKey]StoredPacket
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[storeKey]StoredPacket)}
+}
+
+func (s *MemoryStore) Put(pkt StoredPacket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[storeKey{pkt.Direction, pkt.PacketID}] = pkt
+	return nil
+}
+
+func (s *MemoryStore) Get(dir Direction, id uint16) (StoredPacket, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pkt, ok := s.entries[storeKey{dir, id}]
+	return pkt, ok, nil
+}
+
+func (s *MemoryStore) Delete(dir Direction, id uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, storeKey{dir, id})
+	return nil
+}
+
+func (s *MemoryStore) Iterate(fn func(StoredPacket) bool) error {
+	s.mu.Lock()
+	pkts := make([]StoredPacket, 0, len(s.entries))
+	for _, pkt := range s.entries {
+		pkts = append(pkts, pkt)
+	}
+	s.mu.Unlock()
+
+	sortStored(pkts)
+	for _, pkt := range pkts {
+		if !fn(pkt) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// sortStored orders the entries by Seq, entries written without one by
+// Created.
+func sortStored(pkts []StoredPacket) {
+	sort.Slice(pkts, func(i, j int) bool {
+		a, b := pkts[i], pkts[j]
+		if a.Direction != b.Direction {
+			return a.Direction < b.Direction
+		}
+		if a.Seq != b.Seq {
+			return a.Seq < b.Seq
+		}
+		if !a.Created.Equal(b.Created) {
+			return a.Created.Before(b.Created)
+		}
+		return a.PacketID < b.PacketID
+	})
+}
+
+// FileStore keeps one file per entry in a directory. Every file is written to
+// a temporary file, synced and renamed so a crash never leaves a partial
+// entry behind.
+type FileStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create session store : %w", err)
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(dir Direction, id uint16) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s-%05d.json", dir, id))
+}
+
+func (s *FileStore) Put(pkt StoredPacket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := json.Marshal(pkt)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path(pkt.Direction, pkt.PacketID), b)
+}
+
+func (s *FileStore) Get(dir Direction, id uint16) (StoredPacket, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read(s.path(dir, id))
+}
+
+func (s *FileStore) read(path string) (StoredPacket, bool, error) {
+	var pkt StoredPacket
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return pkt, false, nil
+	}
+	if err != nil {
+		return pkt, false, err
+	}
+
+	if err := json.Unmarshal(b, &pkt); err != nil {
+		return pkt, false, fmt.Errorf("failed to decode %s : %w", filepath.Base(path), err)
+	}
+
+	return pkt, true, nil
+}
+
+func (s *FileStore) Delete(dir Direction, id uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return removeFile(s.path(dir, id))
+}
+
+func (s *FileStore) Iterate(fn func(StoredPacket) bool) error {
+	s.mu.Lock()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	pkts := make([]StoredPacket, 0, len(entries))
+	for _, e := range entries {
+		if !storeFileName(e.Name()) {
+			continue
+		}
+
+		pkt, ok, err := s.read(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		if ok {
+			pkts = append(pkts, pkt)
+		}
+	}
+	s.mu.Unlock()
+
+	sortStored(pkts)
+	for _, pkt := range pkts {
+		if !fn(pkt) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// storeFileName reports whether name is an entry, temporary files left by a
+// crash being skipped.
+func storeFileName(name string) bool {
+	base, ok := strings.CutSuffix(name, ".json")
+	if !ok {
+		return false
+	}
+
+	dir, id, ok := strings.Cut(base, "-")
+	if !ok || (dir != Outbound.String() && dir != Inbound.String()) {
+		return false
+	}
+
+	_, err := strconv.ParseUint(id, 10, 16)
+	return err == nil
+}
+
+// writeFileAtomic writes b to a temporary file of the same directory, syncs
+// it and renames it to path.
+func writeFileAtomic(path string, b []byte) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+func removeFile(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes the renames and removals of a directory durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// storeTurn orders the session store calls of a packet, pc.mu must be held.
+// The calls run outside pc.mu, each one waiting for the previous call for the
+// same packet, so a packet identifier reused meanwhile is never overwritten
+// by a late call.
+func (pc *PorterClient) storeTurn(dir Direction, id uint16, call func() error) func() error {
+	key := storeKey{dir, id}
+	prev := pc.storeTails[key]
+	done := make(chan struct{})
+	pc.storeTails[key] = done
+
+	return func() error {
+		if prev != nil {
+			<-prev
+		}
+
+		err := call()
+		close(done)
+
+		pc.mu.Lock()
+		if pc.storeTails[key] == done {
+			delete(pc.storeTails, key)
+		}
+		pc.mu.Unlock()
+
+		return err
+	}
+}
+
+// persist records an exchange in the session store, pc.mu must be held. The
+// returned function writes it and must be called once pc.mu is released.
+func (pc *PorterClient) persist(pkt StoredPacket) func() error {
+	if pc.store == nil {
+		return func() error { return nil }
+	}
+
+	return pc.storeTurn(pkt.Direction, pkt.PacketID, func() error {
+		if err := pc.store.Put(pkt); err != nil {
+			return fmt.Errorf("failed to persist packet %d : %w", pkt.PacketID, err)
+		}
+		return nil
+	})
+}
+
+// forget removes a completed exchange from the session store, pc.mu must be
+// held. The returned function removes it and must be called once pc.mu is
+// released. An entry that could not be removed is logged and replayed later,
+// which the QoS guarantees allow.
+func (pc *PorterClient) forget(dir Direction, id uint16) func() {
+	if pc.store == nil {
+		return func() {}
+	}
+
+	remove := pc.storeTurn(dir, id, func() error {
+		return pc.store.Delete(dir, id)
+	})
+
+	return func() {
+		if err := remove(); err != nil {
+			pc.logger.Warn("failed to remove packet from session store", "direction", dir.String(), "packet_id", id, "error", err)
+		}
+	}
+}
+
+// loadSession reads the entries of the session store, without pc.mu held so
+// a slow store does not block the client.
+func (pc *PorterClient) loadSession() ([]StoredPacket, error) {
+	if pc.store == nil {
+		return nil, nil
+	}
+
+	var pkts []StoredPacket
+	err := pc.store.Iterate(func(pkt StoredPacket) bool {
+		pkts = append(pkts, pkt)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore session : %w", err)
+	}
+
+	return pkts, nil
+}
+
+// restoreSession tracks the loaded exchanges that are not already tracked,
+// pc.mu must be held.
+func (pc *PorterClient) restoreSession(pkts []StoredPacket) {
+	for _, pkt := range pkts {
+		if pkt.Direction == Inbound {
+			pc.inbound[pkt.PacketID] = struct{}{}
+			continue
+		}
+
+		if _, ok := pc.outbound[pkt.PacketID]; ok {
+			continue
+		}
+
+		// entries come in send order, the ones without a sequence getting
+		// the next one
+		seq := pkt.Seq
+		if seq == 0 {
+			seq = pc.sendSeq + 1
+		}
+		pc.sendSeq = max(pc.sendSeq, seq)
+
+		pc.outbound[pkt.PacketID] = &inflight{
+			id:       pkt.PacketID,
+			msg:      pkt.Message,
+			released: pkt.Released,
+			created:  pkt.Created,
+			seq:      seq,
+			token:    newToken(),
+		}
+	}
+}
+
+// discardInbound drops the inbound QoS 2 identifiers once the server starts
+// a new session, pc.mu must be held. The returned function removes them from
+// the session store once pc.mu is released.
+func (pc *PorterClient) discardInbound() func() {
+	forgets := make([]func(), 0, len(pc.inbound))
+	for id := range pc.inbound {
+		forgets = append(forgets, pc.forget(Inbound, id))
+		delete(pc.inbound, id)
+	}
+
+	return func() {
+		for _, forget := range forgets {
+			forget()
+		}
+	}
+}
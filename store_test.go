@@ -0,0 +1,168 @@
+package portergosdk
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func storedIDs(t *testing.T, s SessionStore) []uint16 {
+	t.Helper()
+
+	var ids []uint16
+	err := s.Iterate(func(pkt StoredPacket) bool {
+		ids = append(ids, pkt.PacketID)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Iterate : %v", err)
+	}
+	return ids
+}
+
+func TestStoreIterateOrder(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		pkts []StoredPacket
+		want []uint16
+	}{
+		{
+			name: "by seq",
+			pkts: []StoredPacket{
+				{Direction: Outbound, PacketID: 1, Seq: 3},
+				{Direction: Outbound, PacketID: 2, Seq: 1},
+				{Direction: Outbound, PacketID: 3, Seq: 2},
+			},
+			want: []uint16{2, 3, 1},
+		},
+		{
+			name: "wrapped identifiers",
+			pkts: []StoredPacket{
+				{Direction: Outbound, PacketID: 65535, Seq: 10},
+				{Direction: Outbound, PacketID: 1, Seq: 11},
+				{Direction: Outbound, PacketID: 65534, Seq: 9},
+			},
+			want: []uint16{65534, 65535, 1},
+		},
+		{
+			name: "outbound first",
+			pkts: []StoredPacket{
+				{Direction: Inbound, PacketID: 1},
+				{Direction: Outbound, PacketID: 7, Seq: 1},
+			},
+			want: []uint16{7, 1},
+		},
+		{
+			name: "without seq by created",
+			pkts: []StoredPacket{
+				{Direction: Outbound, PacketID: 1, Created: created.Add(time.Second)},
+				{Direction: Outbound, PacketID: 2, Created: created},
+				{Direction: Outbound, PacketID: 3, Created: created.Add(2 * time.Second)},
+			},
+			want: []uint16{2, 1, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, err := NewFileStore(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewFileStore : %v", err)
+			}
+
+			for _, s := range []SessionStore{NewMemoryStore(), fs} {
+				for _, pkt := range tt.pkts {
+					if err := s.Put(pkt); err != nil {
+						t.Fatalf("Put : %v", err)
+					}
+				}
+
+				if got := storedIDs(t, s); !slices.Equal(got, tt.want) {
+					t.Fatalf("%T iterated %v, want %v", s, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFileStoreReload(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore : %v", err)
+	}
+
+	msg := AppMessage{TopicName: "a/b", MessageQoS: QoSOne, Payload: []byte("hello")}
+	pkts := []StoredPacket{
+		{Direction: Outbound, PacketID: 2, Seq: 2, Message: msg},
+		{Direction: Outbound, PacketID: 1, Seq: 1, Released: true, Message: msg},
+		{Direction: Inbound, PacketID: 1},
+		{Direction: Inbound, PacketID: 3},
+	}
+	for _, pkt := range pkts {
+		if err := s.Put(pkt); err != nil {
+			t.Fatalf("Put : %v", err)
+		}
+	}
+
+	if err := s.Delete(Inbound, 3); err != nil {
+		t.Fatalf("Delete : %v", err)
+	}
+	if err := s.Delete(Inbound, 4); err != nil {
+		t.Fatalf("Delete of a missing entry : %v", err)
+	}
+
+	// files left by a crash or foreign to the store are skipped
+	for _, name := range []string{".tmp-123", "outbound-1.json.tmp", "outbound-x.json", "other-1.json", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reloaded, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore : %v", err)
+	}
+
+	if got, want := storedIDs(t, reloaded), []uint16{1, 2, 1}; !slices.Equal(got, want) {
+		t.Fatalf("iterated %v, want %v", got, want)
+	}
+
+	pkt, ok, err := reloaded.Get(Outbound, 1)
+	if err != nil || !ok {
+		t.Fatalf("Get = %v, %v", ok, err)
+	}
+	if !pkt.Released || pkt.Message.TopicName != msg.TopicName || string(pkt.Message.Payload) != string(msg.Payload) {
+		t.Fatalf("Get = %+v, want %+v", pkt, pkts[1])
+	}
+
+	if _, ok, err := reloaded.Get(Inbound, 3); ok || err != nil {
+		t.Fatalf("Get of a deleted entry = %v, %v", ok, err)
+	}
+}
+
+func TestStoreIterateStops(t *testing.T) {
+	s := NewMemoryStore()
+	for id := uint16(1); id <= 3; id++ {
+		if err := s.Put(StoredPacket{Direction: Outbound, PacketID: id, Seq: uint64(id)}); err != nil {
+			t.Fatalf("Put : %v", err)
+		}
+	}
+
+	var ids []uint16
+	err := s.Iterate(func(pkt StoredPacket) bool {
+		ids = append(ids, pkt.PacketID)
+		return len(ids) < 2
+	})
+	if err != nil {
+		t.Fatalf("Iterate : %v", err)
+	}
+	if want := []uint16{1, 2}; !slices.Equal(ids, want) {
+		t.Fatalf("iterated %v, want %v", ids, want)
+	}
+}
@@ -1,66 +1,42 @@
 package portergosdk
 
 import (
-	"bytes"
-	"slices"
+	"github.com/macdaih/porter_go_sdk/packets"
+	"github.com/macdaih/porter_go_sdk/topic"
 )
 
+// validateFilters checks the filters before anything is sent.
+func validateFilters(filters []string) error {
+	for _, filter := range filters {
+		if err := topic.ValidateFilter(filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func buildSubscribe(
+	version ProtocolVersion,
 	topics []string,
 	pktID uint16,
+	maxQoS byte,
 ) ([]byte, error) {
-	var (
-		msg,
-		idBuff,
-		propBuff,
-		payloadBuff bytes.Buffer
-	)
-
-	if err := msg.WriteByte(SubscribeCMD); err != nil {
-		return nil, err
-	}
-
-	if err := writeUint16(&idBuff, pktID); err != nil {
-		return nil, err
-	}
-
-	// TODO deal with props
-	if err := encodeVarInt(&propBuff, 0); err != nil {
-		return nil, err
-	}
-
-	// payload
+	subs := make([]packets.Subscription, 0, len(topics))
 	for _, topic := range topics {
-		if err := writeUTFString(&payloadBuff, topic); err != nil {
-			return nil, err
-		}
-
 		// TODO handle subscription options
-		if err := payloadBuff.WriteByte(0); err != nil {
-			return nil, err
-		}
+		subs = append(subs, packets.Subscription{Topic: topic, QoS: maxQoS})
 	}
 
-	if err := encodeVarInt(
-		&msg,
-		(idBuff.Len() + propBuff.Len() + payloadBuff.Len()),
-	); err != nil {
-		return nil, err
-	}
-
-	if _, err := msg.Write(
-		slices.Concat(
-			idBuff.Bytes(),
-			propBuff.Bytes(),
-			payloadBuff.Bytes(),
-		),
-	); err != nil {
-		return nil, err
-	}
-
-	return msg.Bytes(), nil
+	return packets.Codec{Version: version}.Marshal(&packets.Subscribe{
+		PacketID:      pktID,
+		Subscriptions: subs,
+	})
 }
 
-func readSubAck(pkt *packet) ([]byte, error) {
-	return pkt.buffer.Bytes(), nil
+func readSubAck(pkt *packets.Suback) []ReasonCode {
+	codes := make([]ReasonCode, 0, len(pkt.ReasonCodes))
+	for _, b := range pkt.ReasonCodes {
+		codes = append(codes, ReasonCode(b))
+	}
+	return codes
 }
@@ -0,0 +1,67 @@
+package portergosdk
+
+import "context"
+
+// Token reports the outcome of a PublishAsync.
+type Token interface {
+	// Done is closed once the QoS exchange completed or failed.
+	Done() <-chan struct{}
+	// Err returns the failure of the exchange, nil until Done is closed.
+	Err() error
+	// ReasonCode returns the code of the final acknowledgement, ReasonSuccess
+	// for QoS 0, until Done is closed.
+	ReasonCode() ReasonCode
+}
+
+type token struct {
+	done chan struct{}
+	code ReasonCode
+	err  error
+}
+
+func newToken() *token {
+	return &token{done: make(chan struct{})}
+}
+
+func completedToken(code ReasonCode, err error) *token {
+	t := newToken()
+	t.complete(code, err)
+	return t
+}
+
+func (t *token) complete(code ReasonCode, err error) {
+	t.code = code
+	t.err = err
+	close(t.done)
+}
+
+func (t *token) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *token) Err() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+func (t *token) ReasonCode() ReasonCode {
+	select {
+	case <-t.done:
+		return t.code
+	default:
+		return ReasonSuccess
+	}
+}
+
+func (t *token) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.done:
+		return t.err
+	}
+}
@@ -0,0 +1,115 @@
+package portergosdk
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/macdaih/porter_go_sdk/topic"
+)
+
+// Topic publishes and receives values of type T encoded with a Codec.
+type Topic[T any] struct {
+	client *PorterClient
+	filter string
+	codec  Codec
+	qos    QoS
+}
+
+// NewTopic returns a typed topic, filter being the topic name published to
+// and the filter subscribed to. Messages are published at the client QoS.
+func NewTopic[T any](client *PorterClient, filter string, codec Codec) *Topic[T] {
+	return &Topic[T]{
+		client: client,
+		filter: filter,
+		codec:  codec,
+		qos:    client.qos,
+	}
+}
+
+// WithQoS returns a copy of the topic publishing at qos.
+func (t *Topic[T]) WithQoS(qos QoS) *Topic[T] {
+	cp := *t
+	cp.qos = qos
+	return &cp
+}
+
+func (t *Topic[T]) Publish(ctx context.Context, v T) error {
+	return t.PublishTo(ctx, t.filter, v)
+}
+
+// PublishTo publishes v to name, for topics whose filter holds wildcards.
+func (t *Topic[T]) PublishTo(ctx context.Context, name string, v T) error {
+	msg, err := t.Message(name, v)
+	if err != nil {
+		return err
+	}
+
+	return t.client.Publish(ctx, msg)
+}
+
+// Message encodes v into a message for name, to be sent with PublishAsync or
+// Request.
+func (t *Topic[T]) Message(name string, v T) (AppMessage, error) {
+	payload, err := t.codec.Marshal(&v)
+	if err != nil {
+		return AppMessage{}, fmt.Errorf("failed to encode %s payload : %w", name, err)
+	}
+
+	return AppMessage{
+		MessageQoS: t.qos,
+		TopicName:  name,
+		Content:    t.codec.ContentType(),
+		Payload:    payload,
+	}, nil
+}
+
+// Decode checks the content type of msg and decodes its payload, a message
+// without content type being decoded as is.
+func (t *Topic[T]) Decode(msg AppMessage) (T, error) {
+	var v T
+
+	if msg.Content != "" && msg.Content != t.codec.ContentType() {
+		return v, fmt.Errorf("%w : %s carries %s, expected %s", ErrContentType, msg.TopicName, msg.Content, t.codec.ContentType())
+	}
+
+	if err := t.codec.Unmarshal(msg.Payload, &v); err != nil {
+		return v, fmt.Errorf("failed to decode %s payload : %w", msg.TopicName, err)
+	}
+
+	return v, nil
+}
+
+// Subscribe calls fn with the decoded value of every message matching the
+// filter, msg carrying the message metadata. A message that cannot be
+// decoded fails like a handler error.
+func (t *Topic[T]) Subscribe(ctx context.Context, fn func(ctx context.Context, v T, msg AppMessage) error, mws ...Middleware) error {
+	h := func(ctx context.Context, msg AppMessage) error {
+		v, err := t.Decode(msg)
+		if err != nil {
+			return err
+		}
+		return fn(ctx, v, msg)
+	}
+
+	return t.client.Handle(ctx, t.filter, h, mws...)
+}
+
+// HandleTemplate subscribes to the filter of tmpl and calls fn with the
+// parameters parsed from the topic of every matching message.
+func HandleTemplate[P any](
+	ctx context.Context,
+	client *PorterClient,
+	tmpl *topic.Template[P],
+	fn func(ctx context.Context, params P, msg AppMessage) error,
+	mws ...Middleware,
+) error {
+	h := func(ctx context.Context, msg AppMessage) error {
+		params, err := tmpl.Parse(msg.TopicName)
+		if err != nil {
+			return err
+		}
+		return fn(ctx, params, msg)
+	}
+
+	return client.Handle(ctx, tmpl.Filter(), h, mws...)
+}
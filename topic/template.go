@@ -0,0 +1,266 @@
+package topic
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidTemplate = errors.New("invalid topic template")
+
+// Params holds the parameters of a Template by name.
+type Params = map[string]string
+
+// Template renders topic names such as tenants/{tenant}/devices/{device} from
+// parameters of type P, and parses them back. P is either Params or a struct
+// whose fields are bound to the parameters through a `topic:"name"` tag.
+// Parameter values are escaped, so they may hold separators and wildcards.
+type Template[P any] struct {
+	pattern string
+	levels  []string
+	// names maps the index of a parameter level to its name
+	names map[int]string
+	// fields holds the field index of every parameter when P is a struct
+	fields map[string]int
+}
+
+func NewTemplate[P any](pattern string) (*Template[P], error) {
+	t := &Template[P]{
+		pattern: pattern,
+		levels:  Split(pattern),
+		names:   make(map[int]string),
+	}
+
+	literal := make([]string, len(t.levels))
+	seen := make(map[string]bool)
+	for i, level := range t.levels {
+		name, ok := strings.CutPrefix(level, "{")
+		if ok {
+			name, ok = strings.CutSuffix(name, "}")
+		}
+
+		if !ok || strings.ContainsAny(name, "{}") {
+			if strings.ContainsAny(level, "{}") {
+				return nil, fmt.Errorf("%w : %q, a parameter must be a whole level", ErrInvalidTemplate, pattern)
+			}
+			if strings.ContainsAny(level, SingleWildcard+MultiWildcard) {
+				return nil, fmt.Errorf("%w : %q holds a wildcard", ErrInvalidTemplate, pattern)
+			}
+			literal[i] = level
+			continue
+		}
+
+		if name == "" || seen[name] {
+			return nil, fmt.Errorf("%w : %q, parameter names must be set and unique", ErrInvalidTemplate, pattern)
+		}
+		seen[name] = true
+		t.names[i] = name
+		literal[i] = "p"
+	}
+
+	if err := ValidateName(Join(literal...)); err != nil {
+		return nil, fmt.Errorf("%w : %w", ErrInvalidTemplate, err)
+	}
+
+	if err := t.bind(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// MustTemplate is NewTemplate panicking on an invalid pattern, for templates
+// declared as package variables.
+func MustTemplate[P any](pattern string) *Template[P] {
+	t, err := NewTemplate[P](pattern)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// bind resolves the struct field of every parameter.
+func (t *Template[P]) bind() error {
+	rt := reflect.TypeFor[P]()
+	if rt == reflect.TypeFor[Params]() {
+		return nil
+	}
+
+	if rt.Kind() != reflect.Struct {
+		return fmt.Errorf("%w : parameters must be a struct or topic.Params, not %s", ErrInvalidTemplate, rt)
+	}
+
+	t.fields = make(map[string]int)
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		name, ok := field.Tag.Lookup("topic")
+		if !ok {
+			continue
+		}
+
+		if !field.IsExported() {
+			return fmt.Errorf("%w : field %s of %s must be exported", ErrInvalidTemplate, field.Name, rt)
+		}
+		t.fields[name] = i
+	}
+
+	for _, name := range t.names {
+		if _, ok := t.fields[name]; !ok {
+			return fmt.Errorf("%w : %s has no field tagged topic:%q", ErrInvalidTemplate, rt, name)
+		}
+	}
+
+	return nil
+}
+
+func (t *Template[P]) String() string {
+	return t.pattern
+}
+
+// Filter returns the subscription filter matching every rendered name, each
+// parameter being a single level wildcard.
+func (t *Template[P]) Filter() string {
+	levels := make([]string, len(t.levels))
+	for i, level := range t.levels {
+		if _, ok := t.names[i]; ok {
+			level = SingleWildcard
+		}
+		levels[i] = level
+	}
+	return Join(levels...)
+}
+
+func (t *Template[P]) Match(name string) bool {
+	return Match(t.Filter(), name)
+}
+
+// Render returns the topic name for p.
+func (t *Template[P]) Render(p P) (string, error) {
+	levels := make([]string, len(t.levels))
+	copy(levels, t.levels)
+
+	v := reflect.ValueOf(&p).Elem()
+	for i, name := range t.names {
+		var (
+			value string
+			err   error
+		)
+
+		if t.fields == nil {
+			value = v.Interface().(Params)[name]
+		} else {
+			value, err = formatField(v.Field(t.fields[name]))
+			if err != nil {
+				return "", fmt.Errorf("failed to render %s parameter %s : %w", t.pattern, name, err)
+			}
+		}
+
+		if value == "" {
+			return "", fmt.Errorf("failed to render %s : parameter %s is empty", t.pattern, name)
+		}
+		levels[i] = Escape(value)
+	}
+
+	name := Join(levels...)
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Parse extracts the parameters of a name rendered from the template.
+func (t *Template[P]) Parse(name string) (P, error) {
+	var p P
+
+	levels := Split(name)
+	if len(levels) != len(t.levels) {
+		return p, fmt.Errorf("%q does not match %s", name, t.pattern)
+	}
+
+	v := reflect.ValueOf(&p).Elem()
+	if t.fields == nil {
+		v.Set(reflect.ValueOf(make(Params, len(t.names))))
+	}
+
+	for i, level := range levels {
+		param, ok := t.names[i]
+		if !ok {
+			if level != t.levels[i] {
+				return p, fmt.Errorf("%q does not match %s", name, t.pattern)
+			}
+			continue
+		}
+
+		value, err := Unescape(level)
+		if err != nil {
+			return p, err
+		}
+
+		if t.fields == nil {
+			v.Interface().(Params)[param] = value
+			continue
+		}
+
+		if err := parseField(v.Field(t.fields[param]), value); err != nil {
+			return p, fmt.Errorf("failed to parse %s parameter %s : %w", t.pattern, param, err)
+		}
+	}
+
+	return p, nil
+}
+
+func formatField(f reflect.Value) (string, error) {
+	if m, ok := f.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		return string(b), err
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		return f.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(f.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(f.Uint(), 10), nil
+	case reflect.Bool:
+		return strconv.FormatBool(f.Bool()), nil
+	default:
+		return "", fmt.Errorf("unsupported parameter type %s", f.Type())
+	}
+}
+
+func parseField(f reflect.Value, value string) error {
+	if u, ok := f.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported parameter type %s", f.Type())
+	}
+
+	return nil
+}
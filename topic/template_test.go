@@ -0,0 +1,217 @@
+package topic
+
+import (
+	"errors"
+	"maps"
+	"net/netip"
+	"testing"
+)
+
+type device struct {
+	Tenant string     `topic:"tenant"`
+	ID     uint16     `topic:"id"`
+	Online bool       `topic:"online"`
+	Addr   netip.Addr `topic:"addr"`
+	Note   string
+}
+
+func TestNewTemplate(t *testing.T) {
+	tests := []struct {
+		pattern string
+		valid   bool
+	}{
+		{"a/{x}", true},
+		{"{x}", true},
+		{"{x}/{y}", true},
+		{"a//{x}", true},
+		{"", false},
+		{"a/{}", false},
+		{"a/{x}/{x}", false},
+		{"a/x{y}", false},
+		{"a/{x}y", false},
+		{"a/{x}{y}", false},
+		{"a/{{x}}", false},
+		{"a/+/{x}", false},
+		{"a/{x}/#", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			_, err := NewTemplate[Params](tt.pattern)
+			if tt.valid && err != nil {
+				t.Fatalf("NewTemplate(%q) : %v", tt.pattern, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidTemplate) {
+				t.Fatalf("NewTemplate(%q) = %v, want %v", tt.pattern, err, ErrInvalidTemplate)
+			}
+		})
+	}
+}
+
+func TestNewTemplateBinding(t *testing.T) {
+	type unexported struct {
+		tenant string `topic:"tenant"`
+	}
+	type untagged struct {
+		Tenant string
+	}
+
+	tests := []struct {
+		name string
+		new  func() error
+	}{
+		{"not a struct", func() error { _, err := NewTemplate[string]("a/{tenant}"); return err }},
+		{"unexported field", func() error { _, err := NewTemplate[unexported]("a/{tenant}"); return err }},
+		{"untagged field", func() error { _, err := NewTemplate[untagged]("a/{tenant}"); return err }},
+		{"unknown parameter", func() error { _, err := NewTemplate[device]("a/{tenant}/{serial}"); return err }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.new(); !errors.Is(err, ErrInvalidTemplate) {
+				t.Fatalf("NewTemplate = %v, want %v", err, ErrInvalidTemplate)
+			}
+		})
+	}
+}
+
+func TestTemplateFilter(t *testing.T) {
+	tests := []struct {
+		pattern string
+		filter  string
+	}{
+		{"a/b", "a/b"},
+		{"{x}", "+"},
+		{"a/{x}/b", "a/+/b"},
+		{"{x}/{y}", "+/+"},
+		{"$SYS/{x}", "$SYS/+"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := MustTemplate[Params](tt.pattern).Filter(); got != tt.filter {
+				t.Fatalf("Filter() = %q, want %q", got, tt.filter)
+			}
+		})
+	}
+}
+
+func TestTemplateRender(t *testing.T) {
+	tests := []struct {
+		params Params
+		name   string
+		valid  bool
+	}{
+		{Params{"tenant": "acme", "device": "d1"}, "tenants/acme/devices/d1", true},
+		{Params{"tenant": "a/b", "device": "+#"}, "tenants/a%2Fb/devices/%2B%23", true},
+		{Params{"tenant": "100%", "device": "d1", "extra": "x"}, "tenants/100%25/devices/d1", true},
+		{Params{"tenant": "acme"}, "", false},
+		{Params{"tenant": "", "device": "d1"}, "", false},
+	}
+
+	tmpl := MustTemplate[Params]("tenants/{tenant}/devices/{device}")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, err := tmpl.Render(tt.params)
+			if !tt.valid {
+				if err == nil {
+					t.Fatalf("Render(%v) = %q, want an error", tt.params, name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Render(%v) : %v", tt.params, err)
+			}
+			if name != tt.name {
+				t.Fatalf("Render(%v) = %q, want %q", tt.params, name, tt.name)
+			}
+			if !tmpl.Match(name) {
+				t.Fatalf("%q does not match %s", name, tmpl.Filter())
+			}
+
+			params, err := tmpl.Parse(name)
+			if err != nil {
+				t.Fatalf("Parse(%q) : %v", name, err)
+			}
+			want := Params{"tenant": tt.params["tenant"], "device": tt.params["device"]}
+			if !maps.Equal(params, want) {
+				t.Fatalf("Parse(%q) = %v, want %v", name, params, want)
+			}
+		})
+	}
+}
+
+func TestTemplateStruct(t *testing.T) {
+	tests := []struct {
+		dev  device
+		name string
+	}{
+		{device{Tenant: "acme", ID: 7, Online: true, Addr: netip.MustParseAddr("10.0.0.1")}, "acme/7/true/10.0.0.1"},
+		{device{Tenant: "a/b", ID: 65535, Addr: netip.MustParseAddr("::1")}, "a%2Fb/65535/false/::1"},
+	}
+
+	tmpl := MustTemplate[device]("{tenant}/{id}/{online}/{addr}")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, err := tmpl.Render(tt.dev)
+			if err != nil {
+				t.Fatalf("Render(%+v) : %v", tt.dev, err)
+			}
+			if name != tt.name {
+				t.Fatalf("Render(%+v) = %q, want %q", tt.dev, name, tt.name)
+			}
+
+			dev, err := tmpl.Parse(name)
+			if err != nil {
+				t.Fatalf("Parse(%q) : %v", name, err)
+			}
+			if dev != tt.dev {
+				t.Fatalf("Parse(%q) = %+v, want %+v", name, dev, tt.dev)
+			}
+		})
+	}
+}
+
+func TestTemplateParseInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason string
+	}{
+		{"acme/7/true", "too few levels"},
+		{"acme/7/true/10.0.0.1/x", "too many levels"},
+		{"acme/seven/true/10.0.0.1", "not an integer"},
+		{"acme/-1/true/10.0.0.1", "negative unsigned"},
+		{"acme/65536/true/10.0.0.1", "out of range"},
+		{"acme/7/yes/10.0.0.1", "not a bool"},
+		{"acme/7/true/host", "not an address"},
+		{"ac%zz/7/true/10.0.0.1", "invalid escape"},
+	}
+
+	tmpl := MustTemplate[device]("{tenant}/{id}/{online}/{addr}")
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			if dev, err := tmpl.Parse(tt.name); err == nil {
+				t.Fatalf("Parse(%q) = %+v, want an error", tt.name, dev)
+			}
+		})
+	}
+
+	literal := MustTemplate[Params]("tenants/{tenant}")
+	if params, err := literal.Parse("groups/acme"); err == nil {
+		t.Fatalf("Parse(%q) = %v, want an error", "groups/acme", params)
+	}
+}
+
+func TestTemplateUnsupportedType(t *testing.T) {
+	type reading struct {
+		Value float64 `topic:"value"`
+	}
+
+	tmpl := MustTemplate[reading]("readings/{value}")
+	if name, err := tmpl.Render(reading{Value: 1.5}); err == nil {
+		t.Fatalf("Render = %q, want an error", name)
+	}
+	if r, err := tmpl.Parse("readings/1.5"); err == nil {
+		t.Fatalf("Parse = %+v, want an error", r)
+	}
+}
@@ -0,0 +1,173 @@
+// Package topic validates, matches and builds MQTT topic names and filters.
+package topic
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	Separator      = "/"
+	SingleWildcard = "+"
+	MultiWildcard  = "#"
+
+	sharePrefix = "$share/"
+)
+
+var (
+	ErrInvalidName   = errors.New("invalid topic name")
+	ErrInvalidFilter = errors.New("invalid topic filter")
+)
+
+// ValidateName checks a topic name used to publish.
+func ValidateName(name string) error {
+	if err := validateString(name); err != nil {
+		return fmt.Errorf("%w : %w", ErrInvalidName, err)
+	}
+
+	if strings.ContainsAny(name, SingleWildcard+MultiWildcard) {
+		return fmt.Errorf("%w : %q holds a wildcard", ErrInvalidName, name)
+	}
+
+	return nil
+}
+
+// ValidateFilter checks a subscription filter, shared subscriptions
+// included.
+func ValidateFilter(filter string) error {
+	if err := validateString(filter); err != nil {
+		return fmt.Errorf("%w : %w", ErrInvalidFilter, err)
+	}
+
+	inner := filter
+	if rest, ok := strings.CutPrefix(filter, sharePrefix); ok {
+		group, f, ok := strings.Cut(rest, Separator)
+		if !ok || group == "" || f == "" {
+			return fmt.Errorf("%w : %q is not a $share/{group}/{filter} subscription", ErrInvalidFilter, filter)
+		}
+		if strings.ContainsAny(group, SingleWildcard+MultiWildcard) {
+			return fmt.Errorf("%w : share group %q holds a wildcard", ErrInvalidFilter, group)
+		}
+		inner = f
+	}
+
+	levels := Split(inner)
+	for i, level := range levels {
+		switch {
+		case level == MultiWildcard && i != len(levels)-1:
+			return fmt.Errorf("%w : %q has # before the last level", ErrInvalidFilter, filter)
+		case level != MultiWildcard && strings.Contains(level, MultiWildcard):
+			return fmt.Errorf("%w : %q has # within a level", ErrInvalidFilter, filter)
+		case level != SingleWildcard && strings.Contains(level, SingleWildcard):
+			return fmt.Errorf("%w : %q has + within a level", ErrInvalidFilter, filter)
+		}
+	}
+
+	return nil
+}
+
+func validateString(s string) error {
+	if s == "" {
+		return errors.New("empty topic")
+	}
+
+	if len(s) > math.MaxUint16 {
+		return fmt.Errorf("topic of %d bytes exceeds 65535", len(s))
+	}
+
+	if !utf8.ValidString(s) {
+		return errors.New("topic is not valid UTF-8")
+	}
+
+	if strings.ContainsRune(s, 0) {
+		return errors.New("topic holds a null character")
+	}
+
+	return nil
+}
+
+// Match reports whether name matches filter, shared subscriptions matching
+// as their inner filter. Names starting with $ are not matched by a wildcard
+// at the first level.
+func Match(filter, name string) bool {
+	if rest, ok := strings.CutPrefix(filter, sharePrefix); ok {
+		if _, inner, ok := strings.Cut(rest, Separator); ok {
+			filter = inner
+		}
+	}
+
+	if strings.HasPrefix(name, "$") &&
+		(strings.HasPrefix(filter, SingleWildcard) || strings.HasPrefix(filter, MultiWildcard)) {
+		return false
+	}
+
+	fl := Split(filter)
+	nl := Split(name)
+
+	for i, level := range fl {
+		if level == MultiWildcard {
+			return true
+		}
+
+		if i >= len(nl) {
+			return false
+		}
+
+		if level != SingleWildcard && level != nl[i] {
+			return false
+		}
+	}
+
+	return len(fl) == len(nl)
+}
+
+// Split returns the levels of a topic name or filter.
+func Split(topic string) []string {
+	return strings.Split(topic, Separator)
+}
+
+func Join(levels ...string) string {
+	return strings.Join(levels, Separator)
+}
+
+// Escape turns a value into a single topic level, percent-encoding the
+// separator, the wildcards, the null character and % itself.
+func Escape(level string) string {
+	var b strings.Builder
+	for i := 0; i < len(level); i++ {
+		switch c := level[i]; c {
+		case '/', '+', '#', '%', 0:
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Unescape reverses Escape.
+func Unescape(level string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(level); i++ {
+		if level[i] != '%' {
+			b.WriteByte(level[i])
+			continue
+		}
+
+		if i+2 >= len(level) {
+			return "", fmt.Errorf("truncated escape in %q", level)
+		}
+
+		c, err := strconv.ParseUint(level[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q : %w", level, err)
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,138 @@
+package topic
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		name   string
+		want   bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/b", "a/b/c", false},
+		{"#", "a/b/c", true},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a", true},
+		{"a/#", "b/c", false},
+		{"+", "a", true},
+		{"+", "a/b", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a", false},
+		{"a/+", "a/", true},
+		{"+/a", "/a", true},
+		{"a/+/c", "a//c", true},
+		{"+/+", "/", true},
+		{"a//b", "a//b", true},
+		{"a//b", "a/b", false},
+		{"a/b/", "a/b", false},
+		{"#", "$SYS/a", false},
+		{"+/a", "$SYS/a", false},
+		{"$SYS/#", "$SYS/a", true},
+		{"$SYS/+", "$SYS/a", true},
+		{"a/+", "a/$b", true},
+		{"$share/group/a/+", "a/b", true},
+		{"$share/group/#", "a/b", true},
+		{"$share/group/a/+", "b/c", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter+" "+tt.name, func(t *testing.T) {
+			if got := Match(tt.filter, tt.name); got != tt.want {
+				t.Fatalf("Match(%q, %q) = %v, want %v", tt.filter, tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		valid  bool
+	}{
+		{"a/b", true},
+		{"#", true},
+		{"a/#", true},
+		{"+", true},
+		{"+/+/#", true},
+		{"a//b", true},
+		{"/", true},
+		{"$SYS/#", true},
+		{"$share/group/a/+", true},
+		{"", false},
+		{"a/#/b", false},
+		{"#/a", false},
+		{"a#", false},
+		{"a/b#", false},
+		{"a+", false},
+		{"a/+b", false},
+		{"a/\x00", false},
+		{"a/\xff", false},
+		{"$share/group", false},
+		{"$share//a", false},
+		{"$share/group/", false},
+		{"$share/g+/a", false},
+		{"$share/g#/a", false},
+		{"$share/group/a/#/b", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			err := ValidateFilter(tt.filter)
+			if tt.valid && err != nil {
+				t.Fatalf("ValidateFilter(%q) : %v", tt.filter, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidFilter) {
+				t.Fatalf("ValidateFilter(%q) = %v, want %v", tt.filter, err, ErrInvalidFilter)
+			}
+		})
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		value string
+		level string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"a/b", "a%2Fb"},
+		{"x+y#", "x%2By%23"},
+		{"100%", "100%25"},
+		{"%2F", "%252F"},
+		{"\x00", "%00"},
+		{"héllo", "héllo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			level := Escape(tt.value)
+			if level != tt.level {
+				t.Fatalf("Escape(%q) = %q, want %q", tt.value, level, tt.level)
+			}
+
+			if err := ValidateName(level); level != "" && err != nil {
+				t.Fatalf("escaped level %q : %v", level, err)
+			}
+
+			value, err := Unescape(level)
+			if err != nil {
+				t.Fatalf("Unescape(%q) : %v", level, err)
+			}
+			if value != tt.value {
+				t.Fatalf("Unescape(%q) = %q, want %q", level, value, tt.value)
+			}
+		})
+	}
+}
+
+func TestUnescapeInvalid(t *testing.T) {
+	for _, level := range []string{"%", "a%2", "%zz", "%G0"} {
+		if _, err := Unescape(level); err == nil {
+			t.Fatalf("Unescape(%q) succeeded", level)
+		}
+	}
+}
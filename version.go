@@ -0,0 +1,64 @@
+package portergosdk
+
+import (
+	"errors"
+
+	"github.com/macdaih/porter_go_sdk/packets"
+)
+
+type ProtocolVersion = packets.Version
+
+const (
+	V311 = packets.V311
+	V5   = packets.V5
+)
+
+// WithProtocolVersion selects the protocol spoken with the server, MQTT 5
+// being the default. MQTT 3.1.1 carries no properties, so the features built
+// on them (topic aliases, flow control, reason strings...) are disabled.
+func WithProtocolVersion(v ProtocolVersion) Option {
+	return func(c *PorterClient) {
+		c.version = v
+	}
+}
+
+// WithProtocolFallback makes the client retry CONNECT with MQTT 3.1.1 when
+// the server refuses MQTT 5 with Unsupported Protocol Version. The client
+// then keeps using MQTT 3.1.1 for its lifetime.
+func WithProtocolFallback() Option {
+	return func(c *PorterClient) {
+		c.fallback = true
+	}
+}
+
+// fallbackVersion switches to MQTT 3.1.1 when err is a refusal of MQTT 5 the
+// client may recover from, pc.dialMu must be held.
+func (pc *PorterClient) fallbackVersion(err error) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if !pc.fallback || pc.version != V5 || !errors.Is(err, ReasonUnsupportedProtocolVersion) {
+		return false
+	}
+
+	pc.version = V311
+	return true
+}
+
+// returnCodes maps the MQTT 3.1.1 CONNACK return codes to their MQTT 5
+// equivalent.
+var returnCodes = map[byte]ReasonCode{
+	0x00: ReasonSuccess,
+	0x01: ReasonUnsupportedProtocolVersion,
+	0x02: ReasonClientIdentifierNotValid,
+	0x03: ReasonServerUnavailable,
+	0x04: ReasonBadUserNameOrPassword,
+	0x05: ReasonNotAuthorized,
+}
+
+func reasonFromReturnCode(code byte) ReasonCode {
+	if rc, ok := returnCodes[code]; ok {
+		return rc
+	}
+	return ReasonUnspecifiedError
+}
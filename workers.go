@@ -0,0 +1,210 @@
+package portergosdk
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const defaultWorkerQueue = 16
+
+type WorkerOptions struct {
+	// Workers is the number of messages handled concurrently.
+	Workers int
+	// Key returns the ordering key of a message, messages sharing a key
+	// being handled one at a time in arrival order. The topic is used when
+	// unset.
+	Key func(AppMessage) string
+	// Queue is the number of QoS 0 messages waiting per worker, 16 when
+	// unset, the connection reader waiting for room once a worker is full.
+	// QoS 1 and 2 messages are always queued: their acknowledgements are
+	// only sent once they were handled, so the server stops sending them at
+	// the Receive Maximum.
+	Queue int
+	// DropOnFull drops the QoS 0 messages arriving at a full worker instead
+	// of holding the connection reader.
+	DropOnFull bool
+	// Timeout bounds every handler call through its context, 0 for none.
+	Timeout time.Duration
+}
+
+// WithWorkers handles inbound messages on a pool of workers instead of the
+// connection reader. A handler that panics or returns an error is logged and
+// its message acknowledged with Unspecified error, as an inline handler
+// failure is, the server not delivering it again.
+func WithWorkers(opts WorkerOptions) Option {
+	return func(c *PorterClient) {
+		if opts.Queue <= 0 {
+			opts.Queue = defaultWorkerQueue
+		}
+		if opts.Key == nil {
+			opts.Key = func(msg AppMessage) string { return msg.TopicName }
+		}
+		c.workerOpts = opts
+	}
+}
+
+type job struct {
+	c        *connection
+	msg      AppMessage
+	received time.Time
+	fn       func(ctx context.Context) error
+}
+
+// jobQueue holds the messages waiting for a worker. Only its QoS 0 messages
+// are bounded, the others being bounded by the Receive Maximum.
+type jobQueue struct {
+	mu    sync.Mutex
+	jobs  []job
+	qos0  int
+	ready chan struct{}
+	// space is closed and replaced whenever a QoS 0 message leaves the queue
+	space chan struct{}
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}),
+	}
+}
+
+// push queues j and reports whether it was. Once max QoS 0 messages are
+// waiting another one waits for room until ctx ends, or is refused right
+// away when drop is set.
+func (q *jobQueue) push(ctx context.Context, j job, max int, drop bool) bool {
+	for {
+		q.mu.Lock()
+		if j.msg.MessageQoS.level() != 0 || q.qos0 < max {
+			break
+		}
+		space := q.space
+		q.mu.Unlock()
+
+		if drop {
+			return false
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-space:
+		}
+	}
+
+	if j.msg.MessageQoS.level() == 0 {
+		q.qos0++
+	}
+	q.jobs = append(q.jobs, j)
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (q *jobQueue) pop() (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.jobs) == 0 {
+		return job{}, false
+	}
+
+	j := q.jobs[0]
+	q.jobs[0] = job{}
+	q.jobs = q.jobs[1:]
+	if j.msg.MessageQoS.level() == 0 {
+		q.qos0--
+		close(q.space)
+		q.space = make(chan struct{})
+	}
+	return j, true
+}
+
+// startWorkers runs the pool of a connection. The messages still queued when
+// it ends are dropped unacknowledged, the server delivering them again.
+func (pc *PorterClient) startWorkers(c *connection) {
+	if pc.workerOpts.Workers <= 0 {
+		return
+	}
+
+	c.workers = make([]*jobQueue, pc.workerOpts.Workers)
+	for i := range c.workers {
+		c.workers[i] = newJobQueue()
+		go pc.work(c.ctx, c.workers[i])
+	}
+}
+
+func (pc *PorterClient) work(ctx context.Context, q *jobQueue) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.ready:
+		}
+
+		for ctx.Err() == nil {
+			j, ok := q.pop()
+			if !ok {
+				break
+			}
+
+			// the message could not be acknowledged, the connection is reset
+			// so the server delivers it again
+			if err := pc.run(j); err != nil {
+				pc.connectionLost(j.c, fmt.Errorf("%w : %w", ErrConnectionLost, err))
+			}
+		}
+	}
+}
+
+type handlerKey struct{}
+
+// inHandler reports whether ctx is the context of a message handler, which
+// must not wait for an acknowledgement or for room in the send quota as both
+// are freed by the connection reader it runs on or that waits for its worker.
+func inHandler(ctx context.Context) bool {
+	v, _ := ctx.Value(handlerKey{}).(bool)
+	return v
+}
+
+// handle runs fn inline or queues it to the worker owning the message key,
+// its context expiring with the message.
+func (pc *PorterClient) handle(c *connection, msg AppMessage, fn func(ctx context.Context) error) error {
+	received := time.Now()
+
+	if len(c.workers) == 0 {
+		ctx, cancel := messageContext(c.ctx, msg, received)
+		defer cancel()
+
+		return fn(ctx)
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(pc.workerOpts.Key(msg)))
+	worker := c.workers[h.Sum32()%uint32(len(c.workers))]
+
+	// the connection reader waits for room unless dropping is allowed
+	if !worker.push(c.ctx, job{c: c, msg: msg, received: received, fn: fn}, pc.workerOpts.Queue, pc.workerOpts.DropOnFull) &&
+		pc.workerOpts.DropOnFull {
+		c.logger.Warn("dropping message, worker queue full", "topic", msg.TopicName)
+	}
+	return nil
+}
+
+// run calls the job with the handler timeout, only the failure to
+// acknowledge the message being returned.
+func (pc *PorterClient) run(j job) error {
+	ctx, cancel := messageContext(j.c.ctx, j.msg, j.received)
+	defer cancel()
+
+	ctx, cancelTimeout := withTimedContext(ctx, pc.workerOpts.Timeout)
+	defer cancelTimeout()
+
+	return j.fn(ctx)
+}